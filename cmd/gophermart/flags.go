@@ -2,6 +2,10 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
 )
 
 // Адрес и порт для запуска сервера
@@ -13,10 +17,153 @@ var databaseConnStr string
 // Адрес системы расчёта начислений
 var accrualCalculationRouterAddr string
 
+// Сколько ждать регистрации заказа в системе расчёта начислений, прежде чем признать его INVALID
+var accrualNotRegisteredTimeout time.Duration
+
+// Количество параллельных воркеров опроса системы расчёта начислений
+var accrualWorkers int
+
+// Допустимое количество запросов в минуту к системе расчёта начислений (0 - без ограничения)
+var accrualRateLimit int
+
+// На сколько экземпляр сервиса захватывает заказ для опроса системы расчёта начислений
+var accrualLeaseTimeout time.Duration
+
+// Как часто сверять сохранённые балансы с журналом баллов
+var ledgerCheckInterval time.Duration
+
+// Настройки пула соединений с БД (0 - значение по умолчанию pgxpool)
+var dbMaxConns int
+var dbMinConns int
+var dbMaxConnLifetime time.Duration
+var dbMaxConnIdleTime time.Duration
+var dbHealthCheckPeriod time.Duration
+var dbConnectTimeout time.Duration
+
+// Количество воркеров диспетчера задач и размер очереди каждого из них
+var dispatcherWorkers int
+var dispatcherQueueSize int
+
+// Сколько ждать завершения обработки запросов и фоновых задач при остановке
+var shutdownTimeout time.Duration
+
+// Применять ли миграции схемы БД при запуске сервера
+var migrateOnStart bool
+
+// Алгоритм хэширования паролей (bcrypt, argon2id) и его cost (0 - значение по умолчанию для алгоритма)
+var passwordHashAlgorithm string
+var passwordHashCost int
+
+// Ключи подписи JWT: секрет HS256 (строкой или файлом) либо файл закрытого ключа RSA/Ed25519,
+// идентификатор ключа подписи и ключи, которыми ещё принимаются токены ("kid=путь,путь")
+var jwtSecret string
+var jwtSecretFile string
+var jwtSigningKeyFile string
+var jwtKeyID string
+var jwtVerificationKeys string
+
+// Подписывать токены случайным ключом, если ключ не задан (только для локальной разработки)
+var jwtDevRandomKey bool
+
+// Время жизни токена доступа и refresh-токена
+var accessTokenTTL time.Duration
+var refreshTokenTTL time.Duration
+
+// Сколько хранится ключ идемпотентности запроса на списание
+var idempotencyKeyTTL time.Duration
+
+// Через сколько после начисления сгорают баллы (0 - не сгорают) и как часто искать сгоревшие
+var pointsLifetime time.Duration
+var pointsExpiryCheckInterval time.Duration
+
+// Уровни программы лояльности ("имя:порог:множитель,...", пусто - уровни не используются),
+// период, за который суммируются начисления, и как часто пересчитывать уровни
+var tiersConfig string
+var tierWindow time.Duration
+var tierRecomputeInterval time.Duration
+
+// Токен администратора для административных маршрутов (пусто - маршруты отключены)
+var adminToken string
+
 // parseFlags обрабатывает аргументы командной строки и сохраняет их значения в соответствующих переменных
-func parseFlags() {
+func parseFlags(args []string) {
 	flag.StringVar(&routerAddr, "a", ":8080", "address and port to run server")
 	flag.StringVar(&databaseConnStr, "d", "Host=127.0.0.1;Port=5432;Database=exampledb;Username=postgres;Password=password;", "postgresql database connection string")
 	flag.StringVar(&accrualCalculationRouterAddr, "r", ":8080", "address of the accrual calculation system")
-	flag.Parse()
+	flag.DurationVar(&accrualNotRegisteredTimeout, "accrual-not-registered-timeout", 24*time.Hour, "how long to wait for an order to be registered in the accrual system before marking it INVALID")
+	flag.IntVar(&accrualWorkers, "accrual-workers", 4, "number of concurrent accrual polling workers")
+	flag.IntVar(&accrualRateLimit, "accrual-rate-limit", 0, "max requests per minute to the accrual system shared by all workers (0 - unlimited)")
+	flag.DurationVar(&accrualLeaseTimeout, "accrual-lease-timeout", time.Minute, "how long an instance holds a claimed order before other instances may poll it")
+	flag.DurationVar(&ledgerCheckInterval, "ledger-check-interval", time.Hour, "how often to compare balance snapshots with the points ledger (0 - disabled)")
+	flag.IntVar(&dbMaxConns, "db-max-conns", 0, "max number of connections in the database pool")
+	flag.IntVar(&dbMinConns, "db-min-conns", 0, "min number of connections kept open in the database pool")
+	flag.DurationVar(&dbMaxConnLifetime, "db-max-conn-lifetime", 0, "max lifetime of a database connection")
+	flag.DurationVar(&dbMaxConnIdleTime, "db-max-conn-idle-time", 0, "max idle time of a database connection")
+	flag.DurationVar(&dbHealthCheckPeriod, "db-health-check-period", 0, "how often idle database connections are checked")
+	flag.DurationVar(&dbConnectTimeout, "db-connect-timeout", 0, "database connection timeout")
+	flag.IntVar(&dispatcherWorkers, "dispatcher-workers", 16, "number of task dispatcher workers (tasks of one user always run on the same worker)")
+	flag.IntVar(&dispatcherQueueSize, "dispatcher-queue-size", 300, "task queue size of each dispatcher worker")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "grace period for finishing in-flight requests and tasks on shutdown")
+	flag.BoolVar(&migrateOnStart, "migrate-on-start", true, "apply pending database migrations on server start")
+	flag.StringVar(&passwordHashAlgorithm, "password-hash-algorithm", "bcrypt", "password hashing algorithm: bcrypt or argon2id")
+	flag.IntVar(&passwordHashCost, "password-hash-cost", 0, "password hashing cost: bcrypt cost or argon2id iterations (0 - algorithm default)")
+	flag.StringVar(&jwtSecret, "jwt-secret", "", "HS256 secret for signing auth tokens (at least 32 bytes)")
+	flag.StringVar(&jwtSecretFile, "jwt-secret-file", "", "file with the HS256 secret for signing auth tokens")
+	flag.StringVar(&jwtSigningKeyFile, "jwt-signing-key-file", "", "PEM file with an RSA (RS256) or Ed25519 (EdDSA) private key for signing auth tokens")
+	flag.StringVar(&jwtKeyID, "jwt-key-id", "", "key id of the signing key (derived from the key by default)")
+	flag.StringVar(&jwtVerificationKeys, "jwt-verification-keys", "", "comma-separated [kid=]path list of additional keys accepted for token verification")
+	flag.BoolVar(&jwtDevRandomKey, "jwt-dev-random-key", false, "sign tokens with a random key when no key is configured (local development only: tokens are not shared between instances and expire on restart)")
+	flag.DurationVar(&accessTokenTTL, "access-token-ttl", 15*time.Minute, "lifetime of access tokens")
+	flag.DurationVar(&refreshTokenTTL, "refresh-token-ttl", 30*24*time.Hour, "lifetime of refresh tokens (sessions)")
+	flag.DurationVar(&idempotencyKeyTTL, "idempotency-key-ttl", 24*time.Hour, "how long withdrawal idempotency keys and their responses are kept")
+	flag.DurationVar(&pointsLifetime, "points-lifetime", 0, "how long accrued points stay valid after the order is processed, e.g. 8760h (0 - points never expire)")
+	flag.DurationVar(&pointsExpiryCheckInterval, "points-expiry-check-interval", time.Hour, "how often to expire points whose lifetime has passed")
+	flag.StringVar(&tiersConfig, "tiers", "", "loyalty tiers as name:threshold:multiplier list, e.g. BRONZE:0:1,SILVER:5000:1.05,GOLD:20000:1.1 (empty - tiers disabled)")
+	flag.DurationVar(&tierWindow, "tier-window", 365*24*time.Hour, "rolling period of accrued points that determines the user tier")
+	flag.DurationVar(&tierRecomputeInterval, "tier-recompute-interval", time.Hour, "how often to recompute user tiers")
+	flag.StringVar(&adminToken, "admin-token", "", "token required in the X-Admin-Token header of admin endpoints (empty - admin endpoints disabled)")
+	flag.CommandLine.Parse(args)
+}
+
+// stringFromEnv перезаписывает target значением переменной окружения name, если она задана
+func stringFromEnv(name string, target *string) {
+	if envValue, hasEnv := os.LookupEnv(name); hasEnv {
+		*target = envValue
+	}
+}
+
+// durationFromEnv перезаписывает target значением переменной окружения name, если она задана
+func durationFromEnv(name string, target *time.Duration) error {
+	if envValue, hasEnv := os.LookupEnv(name); hasEnv {
+		value, err := time.ParseDuration(envValue)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*target = value
+	}
+	return nil
+}
+
+// boolFromEnv перезаписывает target значением переменной окружения name, если она задана
+func boolFromEnv(name string, target *bool) error {
+	if envValue, hasEnv := os.LookupEnv(name); hasEnv {
+		value, err := strconv.ParseBool(envValue)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*target = value
+	}
+	return nil
+}
+
+// intFromEnv перезаписывает target значением переменной окружения name, если она задана
+func intFromEnv(name string, target *int) error {
+	if envValue, hasEnv := os.LookupEnv(name); hasEnv {
+		value, err := strconv.Atoi(envValue)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*target = value
+	}
+	return nil
 }
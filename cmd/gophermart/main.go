@@ -2,33 +2,53 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/JustScorpio/loyalty_system/internal/accrual"
 	"github.com/JustScorpio/loyalty_system/internal/handlers"
 	"github.com/JustScorpio/loyalty_system/internal/infrastructure"
 	"github.com/JustScorpio/loyalty_system/internal/middleware"
+	"github.com/JustScorpio/loyalty_system/internal/models"
 	"github.com/JustScorpio/loyalty_system/internal/repository/postgres"
+	"github.com/JustScorpio/loyalty_system/internal/repository/postgres/migrations"
 	"github.com/JustScorpio/loyalty_system/internal/services"
+	"github.com/JustScorpio/loyalty_system/internal/utils/auth"
+	"github.com/JustScorpio/loyalty_system/internal/utils/auth/password"
 	"github.com/go-chi/chi"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // функция main вызывается автоматически при запуске приложения
 func main() {
+	// Подкоманда migrate управляет схемой БД и не запускает сервер
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		parseFlags(os.Args[2:])
+		if err := runMigrate(flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// обрабатываем аргументы командной строки
-	parseFlags()
+	parseFlags(os.Args[1:])
 
 	if err := run(); err != nil {
 		log.Fatal(err)
 	}
 }
 
-// функция run будет полезна при инициализации зависимостей сервера перед запуском
-func run() error {
+// loadEnv перезаписывает значения аргументов запуска значениями переменных окружения
+func loadEnv() error {
 	// Берум аргументы запуска приложения из переменных окружения. Иначе - смотрим в переданных явно аргументах
 	// Адрес сервера
 	if envServerAddr, hasEnv := os.LookupEnv("RUN_ADDRESS"); hasEnv {
@@ -40,47 +60,220 @@ func run() error {
 		databaseConnStr = envDBAddr
 	}
 
-	fmt.Println("Connection string: ", databaseConnStr)
-
 	// Адрес системы расчёта начислений
 	if envAccrualConnStr, hasEnv := os.LookupEnv("ACCRUAL_SYSTEM_ADDRESS"); hasEnv {
 		accrualCalculationRouterAddr = envAccrualConnStr
 	}
 
-	db, err := postgres.NewDBConnection(databaseConnStr)
-	if err != nil {
+	// Срок ожидания регистрации заказа в системе расчёта начислений
+	if err := durationFromEnv("ACCRUAL_NOT_REGISTERED_TIMEOUT", &accrualNotRegisteredTimeout); err != nil {
 		return err
 	}
-	defer db.Close(context.Background())
 
-	// Инициализация репозиториев с базой данных
-	usersRepo, err := postgres.NewPgUsersRepo(db)
-	if err != nil {
+	// Количество воркеров опроса системы расчёта начислений
+	if err := intFromEnv("ACCRUAL_WORKERS", &accrualWorkers); err != nil {
+		return err
+	}
+
+	// Лимит запросов в минуту к системе расчёта начислений
+	if err := intFromEnv("ACCRUAL_RATE_LIMIT", &accrualRateLimit); err != nil {
+		return err
+	}
+
+	// Время захвата заказа экземпляром сервиса
+	if err := durationFromEnv("ACCRUAL_LEASE_TIMEOUT", &accrualLeaseTimeout); err != nil {
+		return err
+	}
+
+	// Периодичность сверки балансов с журналом баллов
+	if err := durationFromEnv("LEDGER_CHECK_INTERVAL", &ledgerCheckInterval); err != nil {
+		return err
+	}
+
+	// Настройки диспетчера задач
+	if err := intFromEnv("DISPATCHER_WORKERS", &dispatcherWorkers); err != nil {
+		return err
+	}
+	if err := intFromEnv("DISPATCHER_QUEUE_SIZE", &dispatcherQueueSize); err != nil {
+		return err
+	}
+
+	// Срок корректной остановки сервиса
+	if err := durationFromEnv("SHUTDOWN_TIMEOUT", &shutdownTimeout); err != nil {
+		return err
+	}
+
+	// Настройки пула соединений с БД
+	if err := intFromEnv("DB_MAX_CONNS", &dbMaxConns); err != nil {
+		return err
+	}
+	if err := intFromEnv("DB_MIN_CONNS", &dbMinConns); err != nil {
+		return err
+	}
+	if err := durationFromEnv("DB_MAX_CONN_LIFETIME", &dbMaxConnLifetime); err != nil {
+		return err
+	}
+	if err := durationFromEnv("DB_MAX_CONN_IDLE_TIME", &dbMaxConnIdleTime); err != nil {
+		return err
+	}
+	if err := durationFromEnv("DB_HEALTH_CHECK_PERIOD", &dbHealthCheckPeriod); err != nil {
+		return err
+	}
+	if err := durationFromEnv("DB_CONNECT_TIMEOUT", &dbConnectTimeout); err != nil {
+		return err
+	}
+
+	// Хэширование паролей
+	stringFromEnv("PASSWORD_HASH_ALGORITHM", &passwordHashAlgorithm)
+	if err := intFromEnv("PASSWORD_HASH_COST", &passwordHashCost); err != nil {
+		return err
+	}
+
+	// Ключи подписи токенов
+	stringFromEnv("JWT_SECRET", &jwtSecret)
+	stringFromEnv("JWT_SECRET_FILE", &jwtSecretFile)
+	stringFromEnv("JWT_SIGNING_KEY_FILE", &jwtSigningKeyFile)
+	stringFromEnv("JWT_KEY_ID", &jwtKeyID)
+	stringFromEnv("JWT_VERIFICATION_KEYS", &jwtVerificationKeys)
+	if err := boolFromEnv("JWT_DEV_RANDOM_KEY", &jwtDevRandomKey); err != nil {
+		return err
+	}
+
+	// Время жизни токенов
+	if err := durationFromEnv("ACCESS_TOKEN_TTL", &accessTokenTTL); err != nil {
+		return err
+	}
+	if err := durationFromEnv("REFRESH_TOKEN_TTL", &refreshTokenTTL); err != nil {
+		return err
+	}
+
+	// Срок хранения ключей идемпотентности
+	if err := durationFromEnv("IDEMPOTENCY_KEY_TTL", &idempotencyKeyTTL); err != nil {
+		return err
+	}
+	// Без срока хранения ключи истекали бы сразу, и повтор запроса проводил бы списание второй раз
+	if idempotencyKeyTTL <= 0 {
+		return fmt.Errorf("invalid idempotency key TTL %s: must be positive", idempotencyKeyTTL)
+	}
+
+	// Сгорание баллов
+	if err := durationFromEnv("POINTS_LIFETIME", &pointsLifetime); err != nil {
+		return err
+	}
+	if err := durationFromEnv("POINTS_EXPIRY_CHECK_INTERVAL", &pointsExpiryCheckInterval); err != nil {
+		return err
+	}
+
+	// Уровни программы лояльности
+	stringFromEnv("TIERS", &tiersConfig)
+	if err := durationFromEnv("TIER_WINDOW", &tierWindow); err != nil {
+		return err
+	}
+	if err := durationFromEnv("TIER_RECOMPUTE_INTERVAL", &tierRecomputeInterval); err != nil {
+		return err
+	}
+
+	// Токен администратора
+	stringFromEnv("ADMIN_TOKEN", &adminToken)
+
+	// Миграции схемы БД при запуске
+	if err := boolFromEnv("MIGRATE_ON_START", &migrateOnStart); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// openDB создаёт пул соединений с БД по настройкам из аргументов запуска
+func openDB() (*pgxpool.Pool, error) {
+	return postgres.NewDBPool(databaseConnStr, postgres.PoolConfig{
+		MaxConns:          int32(dbMaxConns),
+		MinConns:          int32(dbMinConns),
+		MaxConnLifetime:   dbMaxConnLifetime,
+		MaxConnIdleTime:   dbMaxConnIdleTime,
+		HealthCheckPeriod: dbHealthCheckPeriod,
+		ConnectTimeout:    dbConnectTimeout,
+	})
+}
+
+// функция run будет полезна при инициализации зависимостей сервера перед запуском
+func run() error {
+	if err := loadEnv(); err != nil {
 		return err
 	}
-	ordersRepo, err := postgres.NewPgOrdersRepo(db)
+
+	//Инициализация менеджера токенов. Без ключа подписи сервис не запускается
+	tokens, err := newTokenManager()
 	if err != nil {
 		return err
 	}
-	withdrawalsRepo, err := postgres.NewPgWithdrawalsRepo(db)
+
+	db, err := openDB()
 	if err != nil {
 		return err
 	}
+	defer db.Close()
 
+	// Приведение схемы БД к актуальной версии
+	if migrateOnStart {
+		version, err := migrations.Up(context.Background(), db)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Database schema version:", version)
+	}
+
+	// Инициализация репозиториев с базой данных
+	usersRepo := postgres.NewPgUsersRepo(db)
+	ordersRepo := postgres.NewPgOrdersRepo(db)
+	withdrawalsRepo := postgres.NewPgWithdrawalsRepo(db)
+	ledgerRepo := postgres.NewPgLedgerRepo(db)
+	sessionsRepo := postgres.NewPgSessionsRepo(db)
+	idempotencyRepo := postgres.NewPgIdempotencyRepo(db)
+	pointLotsRepo := postgres.NewPgPointLotsRepo(db)
+	campaignsRepo := postgres.NewPgCampaignsRepo(db)
 	//Инициализация клиента для работы с системой рассчёта баллов
-	accrualSystemClient := accrual.NewClient(accrualCalculationRouterAddr, 5*time.Second) //Таймаут 5 секунд
+	accrualSystemClient := accrual.NewClient(accrualCalculationRouterAddr, 5*time.Second, accrualRateLimit) //Таймаут 5 секунд
 
 	//Инициализация менеджера транзакций
 	txManager := postgres.NewPgxTransactionManager(db)
 
 	//Инициализация инфраструктуры (очередь задач на обработку)
-	dispatcher := infrastructure.NewTaskDispatcher()
+	dispatcher := infrastructure.NewTaskDispatcher(dispatcherWorkers, dispatcherQueueSize)
+
+	//Инициализация хэшера паролей
+	passwordHasher, err := password.NewHasher(passwordHashAlgorithm, passwordHashCost)
+	if err != nil {
+		return err
+	}
+
+	//Уровни программы лояльности
+	tiers, err := models.ParseTiers(tiersConfig)
+	if err != nil {
+		return err
+	}
 
 	// Инициализация сервисов
-	loyaltyService := services.NewLoyaltyService(usersRepo, ordersRepo, withdrawalsRepo, accrualSystemClient, txManager, dispatcher)
+	loyaltyService := services.NewLoyaltyService(usersRepo, ordersRepo, withdrawalsRepo, ledgerRepo, idempotencyRepo, pointLotsRepo, campaignsRepo, accrualSystemClient, txManager, dispatcher, passwordHasher, services.Config{
+		NotRegisteredTimeout:  accrualNotRegisteredTimeout,
+		AccrualWorkers:        accrualWorkers,
+		InstanceID:            instanceID(),
+		AccrualLeaseTimeout:   accrualLeaseTimeout,
+		LedgerCheckInterval:   ledgerCheckInterval,
+		IdempotencyKeyTTL:     idempotencyKeyTTL,
+		PointsLifetime:        pointsLifetime,
+		PointsExpiryInterval:  pointsExpiryCheckInterval,
+		Tiers:                 tiers,
+		TierWindow:            tierWindow,
+		TierRecomputeInterval: tierRecomputeInterval,
+	})
+
+	sessionService := services.NewSessionService(sessionsRepo, tokens, refreshTokenTTL)
 
 	// Инициализация обработчиков
-	loyaltyHandler := handlers.NewLoyaltyHandler(loyaltyService)
+	loyaltyHandler := handlers.NewLoyaltyHandler(loyaltyService, sessionService)
+	adminHandler := handlers.NewAdminHandler(loyaltyService)
+	healthHandler := handlers.NewHealthHandler(postgres.NewPoolHealth(db))
 
 	//Инициализация логгера
 	zapLogger, err := middleware.NewLogger("Info", true)
@@ -99,18 +292,185 @@ func run() error {
 	r.Group(func(r chi.Router) {
 		r.Post("/api/user/register", loyaltyHandler.Register)
 		r.Post("/api/user/login", loyaltyHandler.Login)
+		r.Post("/api/user/refresh", loyaltyHandler.Refresh)
+		r.Get("/api/health", healthHandler.Health)
 	})
 
 	//Защищённые маршруты с auth middleware
 	r.Group(func(r chi.Router) {
-		r.Use(middleware.AuthMiddleware())
+		r.Use(middleware.AuthMiddleware(sessionService))
 		r.Post("/api/user/orders", loyaltyHandler.UploadOrder)
 		r.Get("/api/user/orders", loyaltyHandler.GetUserOrders)
 		r.Get("/api/user/balance", loyaltyHandler.GetBalance)
+		r.Get("/api/user/balance/expiring", loyaltyHandler.GetExpiringPoints)
+		r.Get("/api/user/tier", loyaltyHandler.GetTier)
 		r.Post("/api/user/balance/withdraw", loyaltyHandler.UploadWithdrawal)
 		r.Get("/api/user/withdrawals", loyaltyHandler.GetUserWithdrawals)
+		r.Post("/api/user/logout", loyaltyHandler.Logout)
+		r.Post("/api/user/logout/all", loyaltyHandler.LogoutAll)
 	})
 
-	fmt.Println("Running server on", routerAddr)
-	return http.ListenAndServe(routerAddr, r)
+	//Административные маршруты
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.AdminMiddleware(adminToken))
+		r.Get("/api/admin/health", healthHandler.Stats)
+		r.Post("/api/admin/withdrawals/{order}/reverse", adminHandler.ReverseWithdrawal)
+		r.Post("/api/admin/users/{login}/adjustments", adminHandler.AdjustBalance)
+		r.Get("/api/admin/campaigns", adminHandler.GetCampaigns)
+		r.Post("/api/admin/campaigns", adminHandler.CreateCampaign)
+		r.Get("/api/admin/campaigns/{id}", adminHandler.GetCampaign)
+		r.Put("/api/admin/campaigns/{id}", adminHandler.UpdateCampaign)
+		r.Delete("/api/admin/campaigns/{id}", adminHandler.DeactivateCampaign)
+	})
+
+	server := &http.Server{
+		Addr:    routerAddr,
+		Handler: r,
+	}
+
+	// Остановка по SIGINT/SIGTERM
+	stopCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		fmt.Println("Running server on", routerAddr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		// Сервер не запустился - фоновые воркеры всё равно нужно остановить до закрытия БД
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if shutdownErr := loyaltyService.Shutdown(shutdownCtx); shutdownErr != nil {
+			log.Printf("Loyalty service shutdown: %v", shutdownErr)
+		}
+		if shutdownErr := sessionService.Shutdown(shutdownCtx); shutdownErr != nil {
+			log.Printf("Session service shutdown: %v", shutdownErr)
+		}
+		return err
+	case <-stopCtx.Done():
+	}
+
+	fmt.Println("Shutting down server")
+	return shutdown(server, loyaltyService, sessionService)
+}
+
+// shutdown останавливает сервис в порядке зависимостей: сначала перестаём принимать запросы и дожидаемся текущих,
+// затем выполняем оставшиеся в очереди задачи и начатые начисления. Соединения с БД закрываются после возврата из run
+func shutdown(server *http.Server, loyaltyService *services.LoyaltyService, sessionService *services.SessionService) error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	var errs []error
+	if err := server.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("http server shutdown: %w", err))
+	}
+	if err := loyaltyService.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("loyalty service shutdown: %w", err))
+	}
+	if err := sessionService.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("session service shutdown: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// runMigrate выполняет подкоманду migrate: up (по умолчанию), down [N], version
+func runMigrate(args []string) error {
+	if err := loadEnv(); err != nil {
+		return err
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	var version int64
+	switch command {
+	case "up":
+		version, err = migrations.Up(ctx, db)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of migrations to revert: %q", args[1])
+			}
+		}
+		version, err = migrations.Down(ctx, db, steps)
+	case "version":
+		version, err = migrations.Version(ctx, db)
+	default:
+		return fmt.Errorf("unknown migrate command %q (expected up, down [N] or version)", command)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Database schema version:", version)
+	return nil
+}
+
+// newTokenManager собирает ключи подписи и проверки токенов из аргументов запуска
+func newTokenManager() (*auth.TokenManager, error) {
+	var signing *auth.Key
+	var err error
+
+	switch {
+	case jwtSigningKeyFile != "":
+		signing, err = auth.LoadKeyFile(jwtKeyID, jwtSigningKeyFile)
+	case jwtSecretFile != "":
+		signing, err = auth.LoadKeyFile(jwtKeyID, jwtSecretFile)
+	case jwtSecret != "":
+		signing, err = auth.NewHMACKey(jwtKeyID, []byte(jwtSecret))
+	case jwtDevRandomKey:
+		// Только для локального запуска: у каждого экземпляра свой ключ, перезапуск завершает все сессии
+		log.Println("WARNING: signing tokens with a random key, sessions will not survive a restart and are not shared between instances")
+		signing, err = auth.NewRandomHMACKey()
+	default:
+		return nil, errors.New("no JWT signing key configured: set JWT_SECRET, JWT_SECRET_FILE or JWT_SIGNING_KEY_FILE (or -jwt-dev-random-key for local development)")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var verification []*auth.Key
+	for _, spec := range strings.Split(jwtVerificationKeys, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		kid, path, hasID := strings.Cut(spec, "=")
+		if !hasID {
+			kid, path = "", spec
+		}
+
+		key, err := auth.LoadKeyFile(kid, path)
+		if err != nil {
+			return nil, err
+		}
+		verification = append(verification, key)
+	}
+
+	return auth.NewTokenManager(accessTokenTTL, signing, verification...)
+}
+
+// instanceID уникальный идентификатор запущенного экземпляра сервиса
+func instanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), time.Now().UnixNano())
 }
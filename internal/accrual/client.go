@@ -5,37 +5,73 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/JustScorpio/loyalty_system/internal/customerrors"
+	"github.com/JustScorpio/loyalty_system/internal/models"
 )
 
+// Пауза по умолчанию, если система начислений не прислала Retry-After
+const defaultRetryAfter = 60 * time.Second
+
+// ErrOrderNotRegistered - заказ ещё не зарегистрирован в системе расчёта начислений (204 No Content)
+var ErrOrderNotRegistered = errors.New("order is not registered in accrual system")
+
 // Client оборачивает работу с API начислений
 type Client struct {
 	baseURL    string
 	httpClient *http.Client
+	limiter    *Limiter // Общий для всех запросов бюджет
 }
 
-// Создать клиент
-func NewClient(baseURL string, timeout time.Duration) *Client {
+// Создать клиент. requestsPerMinute <= 0 - частота запросов ограничивается только ответами 429
+func NewClient(baseURL string, timeout time.Duration, requestsPerMinute int) *Client {
 	return &Client{
 		baseURL: baseURL,
 		httpClient: &http.Client{
 			Timeout: timeout,
 		},
+		limiter: NewLimiter(requestsPerMinute),
 	}
 }
 
 // OrderResponse ответ API начислений
 type OrderResponse struct {
-	Order   string  `json:"order"`
-	Status  string  `json:"status"`
-	Accrual float32 `json:"accrual"`
+	Order   string        `json:"order"`
+	Status  Status        `json:"status"`
+	Accrual models.Points `json:"accrual"`
+}
+
+// RateLimitError - система начислений ответила 429 Too Many Requests
+type RateLimitError struct {
+	RetryAfter time.Duration // Через сколько можно повторить запрос (заголовок Retry-After)
+	Limit      int           // Допустимое количество запросов в минуту (0 - если не удалось разобрать тело ответа)
+}
+
+func (err *RateLimitError) Error() string {
+	return fmt.Sprintf("accrual rate limit exceeded (limit %d rpm), retry after %v", err.Limit, err.RetryAfter)
+}
+
+// Unwrap позволяет обрабатывать ошибку и как обычную HTTPError
+func (err *RateLimitError) Unwrap() error {
+	return customerrors.NewTooManyRequestsError(errors.New("too many requests"))
 }
 
-// GetOrderInfo получает данные о начислении
+// PausedUntil возвращает момент, до которого запросы приостановлены после ответа 429
+func (c *Client) PausedUntil() time.Time {
+	return c.limiter.PausedUntil()
+}
+
+// GetOrderInfo получает данные о начислении. Перед запросом дожидается разрешения общего бюджета запросов
 func (c *Client) GetOrderInfo(ctx context.Context, orderNumber string) (*OrderResponse, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
 	url := fmt.Sprintf("%s/api/orders/%s", c.baseURL, orderNumber)
 
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
@@ -49,6 +85,20 @@ func (c *Client) GetOrderInfo(ctx context.Context, orderNumber string) (*OrderRe
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusTooManyRequests {
+		// Приостанавливаем запросы всех воркеров и подстраиваемся под лимит системы начислений
+		rateLimitErr := parseRateLimitError(resp)
+		c.limiter.Pause(time.Now().Add(rateLimitErr.RetryAfter))
+		if rateLimitErr.Limit > 0 {
+			c.limiter.SetLimit(rateLimitErr.Limit)
+		}
+		return nil, rateLimitErr
+	}
+
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, ErrOrderNotRegistered
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		return nil, customerrors.NewHTTPError(errors.New("unexpected status"), resp.StatusCode)
 	}
@@ -60,3 +110,44 @@ func (c *Client) GetOrderInfo(ctx context.Context, orderNumber string) (*OrderRe
 
 	return &data, nil
 }
+
+// parseRateLimitError разбирает заголовок Retry-After и тело "No more than N requests per minute allowed"
+func parseRateLimitError(resp *http.Response) *RateLimitError {
+	rateLimitErr := &RateLimitError{
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err == nil {
+		var limit int
+		if _, err := fmt.Sscanf(strings.TrimSpace(string(body)), "No more than %d requests per minute allowed", &limit); err == nil && limit > 0 {
+			rateLimitErr.Limit = limit
+		}
+	}
+
+	return rateLimitErr
+}
+
+// parseRetryAfter поддерживает оба формата заголовка: количество секунд и HTTP-дату
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return defaultRetryAfter
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return defaultRetryAfter
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := date.Sub(now); wait > 0 {
+			return wait
+		}
+		return 0
+	}
+
+	return defaultRetryAfter
+}
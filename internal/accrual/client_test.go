@@ -0,0 +1,135 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/JustScorpio/loyalty_system/internal/customerrors"
+	"github.com/JustScorpio/loyalty_system/internal/models"
+)
+
+func TestGetOrderInfoRateLimited(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("No more than 30 requests per minute allowed"))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, time.Second, 0)
+	before := time.Now()
+
+	_, err := client.GetOrderInfo(context.Background(), "12345678903")
+
+	var rateLimitErr *RateLimitError
+	if !errors.As(err, &rateLimitErr) {
+		t.Fatalf("expected *RateLimitError, got %v", err)
+	}
+	if rateLimitErr.RetryAfter != 60*time.Second {
+		t.Errorf("RetryAfter = %v, want 60s", rateLimitErr.RetryAfter)
+	}
+	if rateLimitErr.Limit != 30 {
+		t.Errorf("Limit = %d, want 30", rateLimitErr.Limit)
+	}
+
+	var httpErr *customerrors.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected HTTPError 429 in chain, got %v", err)
+	}
+
+	// Общий бюджет приостановлен до истечения Retry-After и подстроен под лимит из тела ответа
+	client.limiter.mu.Lock()
+	pausedUntil, interval := client.limiter.pausedUntil, client.limiter.interval
+	client.limiter.mu.Unlock()
+
+	if pausedUntil.Before(before.Add(60 * time.Second)) {
+		t.Errorf("limiter paused until %v, want at least %v", pausedUntil, before.Add(60*time.Second))
+	}
+	if interval != 2*time.Second {
+		t.Errorf("limiter interval = %v, want 2s (30 rpm)", interval)
+	}
+
+	if got := client.PausedUntil(); !got.Equal(pausedUntil) {
+		t.Errorf("PausedUntil() = %v, want %v", got, pausedUntil)
+	}
+
+	// Запрос, который не дождётся окончания паузы, не ждёт впустую и сообщает, когда его можно повторить
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	waitStarted := time.Now()
+	_, err = client.GetOrderInfo(ctx, "12345678903")
+
+	var waitErr *WaitError
+	if !errors.As(err, &waitErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetOrderInfo during pause = %v, want *WaitError with deadline exceeded", err)
+	}
+	if !waitErr.AllowedAt.Equal(pausedUntil) {
+		t.Errorf("AllowedAt = %v, want end of pause %v", waitErr.AllowedAt, pausedUntil)
+	}
+	if elapsed := time.Since(waitStarted); elapsed > time.Second {
+		t.Errorf("GetOrderInfo blocked for %v during pause", elapsed)
+	}
+}
+
+func TestGetOrderInfoNotRegistered(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	_, err := NewClient(server.URL, time.Second, 0).GetOrderInfo(context.Background(), "12345678903")
+	if !errors.Is(err, ErrOrderNotRegistered) {
+		t.Fatalf("expected ErrOrderNotRegistered, got %v", err)
+	}
+}
+
+func TestGetOrderInfoProcessed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/orders/12345678903" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"order":"12345678903","status":"PROCESSED","accrual":729.98}`))
+	}))
+	defer server.Close()
+
+	info, err := NewClient(server.URL, time.Second, 0).GetOrderInfo(context.Background(), "12345678903")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Status != StatusProcessed || info.Accrual != models.Points(72998) {
+		t.Errorf("got %+v", info)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{"seconds", "120", 120 * time.Second},
+		{"zero", "0", 0},
+		{"empty", "", defaultRetryAfter},
+		{"negative", "-5", defaultRetryAfter},
+		{"garbage", "soon", defaultRetryAfter},
+		{"http date", now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{"http date in the past", now.Add(-time.Hour).Format(http.TimeFormat), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value, now); got != tt.want {
+				t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
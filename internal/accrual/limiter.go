@@ -0,0 +1,102 @@
+package accrual
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// WaitError запрос не дождался разрешения бюджета: контекст истёк или истечёт раньше, чем запрос можно будет отправить
+type WaitError struct {
+	AllowedAt time.Time // Когда бюджет позволит отправить запрос
+	Err       error     // Ошибка контекста
+}
+
+func (err *WaitError) Error() string {
+	return fmt.Sprintf("accrual request budget is exhausted until %v: %v", err.AllowedAt.Format(time.RFC3339), err.Err)
+}
+
+func (err *WaitError) Unwrap() error {
+	return err.Err
+}
+
+// Limiter общий бюджет запросов к системе начислений для всех воркеров.
+// Ограничивает частоту запросов и позволяет полностью приостановить их после ответа 429
+type Limiter struct {
+	mu          sync.Mutex
+	interval    time.Duration // Минимальный интервал между запросами (0 - без ограничений)
+	next        time.Time     // Раньше этого момента следующий запрос не отправляется
+	pausedUntil time.Time     // До этого момента запросы не отправляются вовсе
+}
+
+// Создать ограничитель. requestsPerMinute <= 0 - без ограничения частоты
+func NewLimiter(requestsPerMinute int) *Limiter {
+	l := &Limiter{}
+	l.SetLimit(requestsPerMinute)
+	return l
+}
+
+// SetLimit устанавливает допустимое количество запросов в минуту
+func (l *Limiter) SetLimit(requestsPerMinute int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.interval = 0
+	if requestsPerMinute > 0 {
+		l.interval = time.Minute / time.Duration(requestsPerMinute)
+	}
+}
+
+// Pause приостанавливает все запросы до момента until
+func (l *Limiter) Pause(until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// PausedUntil возвращает момент окончания паузы после ответа 429 (в прошлом - паузы нет)
+func (l *Limiter) PausedUntil() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.pausedUntil
+}
+
+// Wait блокирует, пока бюджет не позволит отправить очередной запрос, или пока не отменён контекст.
+// Если контекст истечёт раньше, чем запрос будет разрешён, сразу возвращает *WaitError
+func (l *Limiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		allowedAt := l.next
+		if l.pausedUntil.After(allowedAt) {
+			allowedAt = l.pausedUntil
+		}
+
+		if !now.Before(allowedAt) {
+			if l.interval > 0 {
+				l.next = now.Add(l.interval)
+			}
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		if deadline, ok := ctx.Deadline(); ok && deadline.Before(allowedAt) {
+			return &WaitError{AllowedAt: allowedAt, Err: context.DeadlineExceeded}
+		}
+
+		// Ждём и проверяем заново - за это время могла прийти новая пауза или запрос мог забрать другой воркер
+		timer := time.NewTimer(allowedAt.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return &WaitError{AllowedAt: allowedAt, Err: ctx.Err()}
+		case <-timer.C:
+		}
+	}
+}
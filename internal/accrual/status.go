@@ -0,0 +1,36 @@
+package accrual
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/JustScorpio/loyalty_system/internal/models"
+)
+
+// Status статус расчёта начисления в системе расчёта начислений
+type Status string
+
+const (
+	StatusRegistered Status = "REGISTERED" // заказ зарегистрирован, но начисление не рассчитано
+	StatusInvalid    Status = "INVALID"    // заказ не принят к расчёту
+	StatusProcessing Status = "PROCESSING" // расчёт начисления в процессе
+	StatusProcessed  Status = "PROCESSED"  // расчёт начисления окончен
+)
+
+// ErrUnknownStatus - система начислений вернула статус, которого нет в протоколе
+var ErrUnknownStatus = errors.New("unknown accrual status")
+
+// ToOrderStatus переводит статус системы начислений в статус заказа
+func (s Status) ToOrderStatus() (models.Status, error) {
+	switch s {
+	case StatusRegistered, StatusProcessing:
+		// Заказ уже попал в систему расчёта - для пользователя он в обработке
+		return models.StatusProcessing, nil
+	case StatusInvalid:
+		return models.StatusInvalid, nil
+	case StatusProcessed:
+		return models.StatusProcessed, nil
+	}
+
+	return "", fmt.Errorf("%w: %q", ErrUnknownStatus, string(s))
+}
@@ -12,6 +12,7 @@ type contextKey int
 const (
 	userIDKey contextKey = iota
 	txKey
+	sessionIDKey
 )
 
 func WithUserID(ctx context.Context, userID string) context.Context {
@@ -27,6 +28,17 @@ func GetUserID(ctx context.Context) string {
 	return userID.(string)
 }
 
+// WithSessionID добавляет в контекст идентификатор сессии, которой принадлежит токен запроса
+func WithSessionID(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionIDKey, sessionID)
+}
+
+// GetSessionID извлекает идентификатор сессии
+func GetSessionID(ctx context.Context) string {
+	sessionID, _ := ctx.Value(sessionIDKey).(string)
+	return sessionID
+}
+
 // WithTx добавляет транзакцию в контекст
 func WithTx(ctx context.Context, tx pgx.Tx) context.Context {
 	return context.WithValue(ctx, txKey, tx)
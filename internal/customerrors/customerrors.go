@@ -84,3 +84,17 @@ func NewPaymentRequiredError(err error) error {
 		Err:  err,
 	}
 }
+
+func NewUnauthorizedError(err error) error {
+	return &HTTPError{
+		Code: http.StatusUnauthorized,
+		Err:  err,
+	}
+}
+
+func NewNotFoundError(err error) error {
+	return &HTTPError{
+		Code: http.StatusNotFound,
+		Err:  err,
+	}
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/JustScorpio/loyalty_system/internal/customerrors"
+	"github.com/JustScorpio/loyalty_system/internal/models"
+	"github.com/JustScorpio/loyalty_system/internal/services"
+	"github.com/go-chi/chi"
+)
+
+// Максимальная длина причины отмены списания
+const maxReversalReasonLen = 500
+
+// Максимальная длина идентификатора корректировки баланса
+const maxAdjustmentReferenceLen = 100
+
+// AdminHandler административные операции
+type AdminHandler struct {
+	service *services.LoyaltyService
+}
+
+func NewAdminHandler(service *services.LoyaltyService) *AdminHandler {
+	return &AdminHandler{
+		service: service,
+	}
+}
+
+// Отменить списание и вернуть баллы пользователю
+func (h *AdminHandler) ReverseWithdrawal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		// разрешаем только POST-запросы
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	order := chi.URLParam(r, "order")
+
+	//Читаем тело запроса
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	var reqData struct {
+		Reason string `json:"reason"`
+	}
+
+	if err = json.Unmarshal(body, &reqData); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	//Причина обязательна - она остаётся в истории списаний
+	reqData.Reason = strings.TrimSpace(reqData.Reason)
+	if reqData.Reason == "" || len(reqData.Reason) > maxReversalReasonLen {
+		http.Error(w, "reason is required and must not exceed 500 bytes", http.StatusBadRequest)
+		return
+	}
+
+	withdrawal, err := h.service.ReverseWithdrawal(r.Context(), order, reqData.Reason)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		var httpErr *customerrors.HTTPError
+		if errors.As(err, &httpErr) {
+			statusCode = httpErr.Code
+		}
+
+		w.WriteHeader(statusCode)
+		return
+	}
+
+	respData := struct {
+		UserID         string                  `json:"user_id"`
+		Order          string                  `json:"order"`
+		Sum            models.Points           `json:"sum"`
+		Status         models.WithdrawalStatus `json:"status"`
+		ProcessedAt    time.Time               `json:"processed_at"`
+		ReversedAt     *time.Time              `json:"reversed_at,omitempty"`
+		ReversalReason string                  `json:"reversal_reason,omitempty"`
+	}{
+		UserID:         withdrawal.UserID,
+		Order:          withdrawal.Order,
+		Sum:            withdrawal.Sum,
+		Status:         withdrawal.Status,
+		ProcessedAt:    withdrawal.ProcessedAt,
+		ReversedAt:     withdrawal.ReversedAt,
+		ReversalReason: withdrawal.ReversalReason,
+	}
+
+	jsonData, err := json.Marshal(respData)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
+
+// Скорректировать текущий баланс пользователя (amount может быть отрицательным)
+func (h *AdminHandler) AdjustBalance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		// разрешаем только POST-запросы
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	login := chi.URLParam(r, "login")
+
+	//Читаем тело запроса
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	var reqData struct {
+		Reference string        `json:"reference"`
+		Amount    models.Points `json:"amount"`
+	}
+
+	if err = json.Unmarshal(body, &reqData); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	//Идентификатор обязателен - по нему корректировка находится в журнале и не проводится повторно
+	reqData.Reference = strings.TrimSpace(reqData.Reference)
+	if reqData.Reference == "" || len(reqData.Reference) > maxAdjustmentReferenceLen {
+		http.Error(w, "reference is required and must not exceed 100 bytes", http.StatusBadRequest)
+		return
+	}
+	if reqData.Amount == 0 {
+		http.Error(w, "amount must not be zero", http.StatusBadRequest)
+		return
+	}
+
+	balance, err := h.service.AdjustBalance(r.Context(), login, reqData.Reference, reqData.Amount)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Current   models.Points `json:"current"`
+		Withdrawn models.Points `json:"withdrawn"`
+		Expired   models.Points `json:"expired"`
+	}{
+		Current:   balance.Current,
+		Withdrawn: balance.Withdrawn,
+		Expired:   balance.Expired,
+	})
+}
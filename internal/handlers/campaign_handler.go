@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/JustScorpio/loyalty_system/internal/customerrors"
+	"github.com/JustScorpio/loyalty_system/internal/models"
+	"github.com/go-chi/chi"
+)
+
+// Параметры акции в запросе. Необязательные поля: multiplier (по умолчанию 1) и active (по умолчанию true)
+type campaignRequest struct {
+	Name           string         `json:"name"`
+	StartsAt       time.Time      `json:"starts_at"`
+	EndsAt         time.Time      `json:"ends_at"`
+	Multiplier     *models.Points `json:"multiplier"`
+	FixedBonus     models.Points  `json:"fixed_bonus"`
+	FirstOrderOnly bool           `json:"first_order_only"`
+	MinAccrual     models.Points  `json:"min_accrual"`
+	Tiers          []string       `json:"tiers"`
+	Active         *bool          `json:"active"`
+}
+
+type campaignResponse struct {
+	ID             int64         `json:"id"`
+	Name           string        `json:"name"`
+	StartsAt       time.Time     `json:"starts_at"`
+	EndsAt         time.Time     `json:"ends_at"`
+	Multiplier     models.Points `json:"multiplier"`
+	FixedBonus     models.Points `json:"fixed_bonus"`
+	FirstOrderOnly bool          `json:"first_order_only"`
+	MinAccrual     models.Points `json:"min_accrual"`
+	Tiers          []string      `json:"tiers"`
+	Active         bool          `json:"active"`
+	CreatedAt      time.Time     `json:"created_at"`
+}
+
+func newCampaignResponse(campaign *models.Campaign) campaignResponse {
+	tiers := campaign.Tiers
+	if tiers == nil {
+		tiers = []string{}
+	}
+
+	return campaignResponse{
+		ID:             campaign.ID,
+		Name:           campaign.Name,
+		StartsAt:       campaign.StartsAt,
+		EndsAt:         campaign.EndsAt,
+		Multiplier:     campaign.Multiplier,
+		FixedBonus:     campaign.FixedBonus,
+		FirstOrderOnly: campaign.FirstOrderOnly,
+		MinAccrual:     campaign.MinAccrual,
+		Tiers:          tiers,
+		Active:         campaign.Active,
+		CreatedAt:      campaign.CreatedAt,
+	}
+}
+
+// Список акций
+func (h *AdminHandler) GetCampaigns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		// разрешаем только Get-запросы
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	campaigns, err := h.service.GetCampaigns(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respData := make([]campaignResponse, 0, len(campaigns))
+	for i := range campaigns {
+		respData = append(respData, newCampaignResponse(&campaigns[i]))
+	}
+
+	writeJSON(w, http.StatusOK, respData)
+}
+
+// Получить акцию
+func (h *AdminHandler) GetCampaign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		// разрешаем только Get-запросы
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, ok := campaignID(w, r)
+	if !ok {
+		return
+	}
+
+	campaign, err := h.service.GetCampaign(r.Context(), id)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newCampaignResponse(campaign))
+}
+
+// Создать акцию
+func (h *AdminHandler) CreateCampaign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		// разрешаем только POST-запросы
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	campaign, ok := readCampaign(w, r)
+	if !ok {
+		return
+	}
+
+	created, err := h.service.CreateCampaign(r.Context(), campaign)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, newCampaignResponse(created))
+}
+
+// Изменить акцию (все поля заменяются)
+func (h *AdminHandler) UpdateCampaign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		// разрешаем только PUT-запросы
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, ok := campaignID(w, r)
+	if !ok {
+		return
+	}
+
+	campaign, ok := readCampaign(w, r)
+	if !ok {
+		return
+	}
+	campaign.ID = id
+
+	updated, err := h.service.UpdateCampaign(r.Context(), campaign)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newCampaignResponse(updated))
+}
+
+// Отключить акцию. Начисленные по ней бонусы сохраняются
+func (h *AdminHandler) DeactivateCampaign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		// разрешаем только DELETE-запросы
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, ok := campaignID(w, r)
+	if !ok {
+		return
+	}
+
+	campaign, err := h.service.DeactivateCampaign(r.Context(), id)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newCampaignResponse(campaign))
+}
+
+// campaignID идентификатор акции из пути запроса. При ошибке ответ уже записан
+func campaignID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid campaign id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// readCampaign разбирает параметры акции из тела запроса. При ошибке ответ уже записан
+func readCampaign(w http.ResponseWriter, r *http.Request) (models.Campaign, bool) {
+	//Читаем тело запроса
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body: "+err.Error(), http.StatusBadRequest)
+		return models.Campaign{}, false
+	}
+	defer r.Body.Close()
+
+	//Только Content-Type: JSON
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusBadRequest)
+		return models.Campaign{}, false
+	}
+
+	var reqData campaignRequest
+	if err = json.Unmarshal(body, &reqData); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return models.Campaign{}, false
+	}
+
+	campaign := models.Campaign{
+		Name:           reqData.Name,
+		StartsAt:       reqData.StartsAt,
+		EndsAt:         reqData.EndsAt,
+		Multiplier:     models.Points(100), // 1 - без изменения (множитель в сотых, как и баллы)
+		FixedBonus:     reqData.FixedBonus,
+		FirstOrderOnly: reqData.FirstOrderOnly,
+		MinAccrual:     reqData.MinAccrual,
+		Tiers:          reqData.Tiers,
+		Active:         true,
+	}
+	if reqData.Multiplier != nil {
+		campaign.Multiplier = *reqData.Multiplier
+	}
+	if reqData.Active != nil {
+		campaign.Active = *reqData.Active
+	}
+
+	return campaign, true
+}
+
+// writeServiceError отвечает статусом ошибки сервиса. Сообщение передаётся клиенту только для ошибок запроса
+func writeServiceError(w http.ResponseWriter, err error) {
+	var httpErr *customerrors.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.Code >= http.StatusInternalServerError {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	http.Error(w, httpErr.Error(), httpErr.Code)
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, data interface{}) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(jsonData)
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// IHealthChecker источник сведений о состоянии хранилища
+type IHealthChecker interface {
+	Health(ctx context.Context) (interface{}, error)
+}
+
+type HealthHandler struct {
+	checker IHealthChecker
+}
+
+func NewHealthHandler(checker IHealthChecker) *HealthHandler {
+	return &HealthHandler{
+		checker: checker,
+	}
+}
+
+// Публичная проверка доступности сервиса: только статус 200 или 503, без подробностей
+func (h *HealthHandler) Health(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		// разрешаем только Get-запросы
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, statusCode := h.check(r.Context())
+	w.WriteHeader(statusCode)
+}
+
+// Состояние сервиса и статистика пула соединений с БД (административный маршрут)
+func (h *HealthHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		// разрешаем только Get-запросы
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// БД недоступна - 503, но статистику всё равно отдаём
+	stats, statusCode := h.check(r.Context())
+
+	jsonData, err := json.Marshal(stats)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(jsonData)
+}
+
+// check опрашивает хранилище. Текст ошибки может содержать адрес и параметры подключения к БД,
+// поэтому он пишется только в лог
+func (h *HealthHandler) check(ctx context.Context) (interface{}, int) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	stats, err := h.checker.Health(ctx)
+	if err != nil {
+		log.Printf("Health check failed: %v", err)
+		return stats, http.StatusServiceUnavailable
+	}
+	return stats, http.StatusOK
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeHealthChecker struct {
+	err error
+}
+
+func (c fakeHealthChecker) Health(ctx context.Context) (interface{}, error) {
+	return map[string]bool{"healthy": c.err == nil}, c.err
+}
+
+func TestHealthDoesNotExposeErrors(t *testing.T) {
+	dbErr := errors.New("failed to connect to host=db.internal user=postgres")
+
+	tests := []struct {
+		name       string
+		checker    fakeHealthChecker
+		handler    func(h *HealthHandler) http.HandlerFunc
+		wantStatus int
+	}{
+		{"public healthy", fakeHealthChecker{}, func(h *HealthHandler) http.HandlerFunc { return h.Health }, http.StatusOK},
+		{"public unhealthy", fakeHealthChecker{err: dbErr}, func(h *HealthHandler) http.HandlerFunc { return h.Health }, http.StatusServiceUnavailable},
+		{"stats unhealthy", fakeHealthChecker{err: dbErr}, func(h *HealthHandler) http.HandlerFunc { return h.Stats }, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler(NewHealthHandler(tt.checker))(rec, httptest.NewRequest(http.MethodGet, "/api/health", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if strings.Contains(rec.Body.String(), "db.internal") {
+				t.Errorf("response exposes the database error: %s", rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JustScorpio/loyalty_system/internal/models"
+)
+
+// Максимальный размер страницы
+const maxPageLimit = 1000
+
+// pageParams параметры постраничной выдачи (limit, cursor). paginated - передан хотя бы один из них:
+// только в этом случае ответ оборачивается в {"items": ..., "next_cursor": ...}, иначе остаётся массивом
+type pageParams struct {
+	limit     int
+	cursor    *models.Cursor
+	paginated bool
+}
+
+// pageResponse ответ постраничной выдачи
+type pageResponse[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+func parsePageParams(query url.Values) (pageParams, error) {
+	var params pageParams
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return params, fmt.Errorf("limit must be an integer from 1 to %d", maxPageLimit)
+		}
+		params.limit = limit
+		params.paginated = true
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := models.DecodeCursor(value)
+		if err != nil {
+			return params, err
+		}
+		params.cursor = cursor
+		params.paginated = true
+	}
+
+	return params, nil
+}
+
+// parseTimeRange читает диапазон из параметров <prefix>_from и <prefix>_to (RFC3339 или дата YYYY-MM-DD).
+// Дата в <prefix>_to включает весь день
+func parseTimeRange(query url.Values, prefix string) (models.TimeRange, error) {
+	var r models.TimeRange
+	var err error
+
+	if value := query.Get(prefix + "_from"); value != "" {
+		if r.From, _, err = parseTime(value); err != nil {
+			return r, fmt.Errorf("invalid %s_from: %w", prefix, err)
+		}
+	}
+
+	if value := query.Get(prefix + "_to"); value != "" {
+		to, dateOnly, err := parseTime(value)
+		if err != nil {
+			return r, fmt.Errorf("invalid %s_to: %w", prefix, err)
+		}
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		r.To = to
+	}
+
+	return r, nil
+}
+
+func parseTime(value string) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	return t, false, err
+}
+
+// parseStatuses читает фильтр по статусам: status=NEW,PROCESSING или status=NEW&status=PROCESSING
+func parseStatuses(query url.Values) ([]models.Status, error) {
+	var statuses []models.Status
+	for _, value := range query["status"] {
+		for _, s := range strings.Split(value, ",") {
+			status := models.Status(strings.ToUpper(strings.TrimSpace(s)))
+			switch status {
+			case models.StatusNew, models.StatusProcessing, models.StatusInvalid, models.StatusProcessed:
+				statuses = append(statuses, status)
+			default:
+				return nil, fmt.Errorf("unknown order status %q", s)
+			}
+		}
+	}
+	return statuses, nil
+}
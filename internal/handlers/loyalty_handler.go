@@ -11,16 +11,23 @@ import (
 	"github.com/JustScorpio/loyalty_system/internal/customerrors"
 	"github.com/JustScorpio/loyalty_system/internal/models"
 	"github.com/JustScorpio/loyalty_system/internal/services"
-	"github.com/JustScorpio/loyalty_system/internal/utils/auth" //В файле middleware не только сама middleware, но и ауфные функции и константы
 )
 
+// Максимальная длина ключа идемпотентности
+const maxIdempotencyKeyLen = 255
+
+// За какой срок по умолчанию показывать сгорающие баллы
+const defaultExpiringWithin = 30 * 24 * time.Hour
+
 type LoyaltyHandler struct {
-	service *services.LoyaltyService
+	service  *services.LoyaltyService
+	sessions *services.SessionService
 }
 
-func NewLoyaltyHandler(service *services.LoyaltyService) *LoyaltyHandler {
+func NewLoyaltyHandler(service *services.LoyaltyService, sessions *services.SessionService) *LoyaltyHandler {
 	return &LoyaltyHandler{
-		service: service,
+		service:  service,
+		sessions: sessions,
 	}
 }
 
@@ -54,8 +61,9 @@ func (h *LoyaltyHandler) Register(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var reqData struct {
-		Login    string `json:"login"`
-		Password string `json:"password"`
+		Login       string `json:"login"`
+		Password    string `json:"password"`
+		ReturnToken bool   `json:"return_token"` // Вернуть токен и в теле ответа
 	}
 
 	if err = json.Unmarshal(body, &reqData); err != nil {
@@ -79,22 +87,13 @@ func (h *LoyaltyHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	//Авторизуем пользователя и устанавливаем куки
-	token, err := auth.GenerateToken(user.Login)
+	//Открываем сессию и выдаём токены
+	tokens, err := h.sessions.Start(r.Context(), user.Login)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-
-	http.SetCookie(w, &http.Cookie{
-		Name:     auth.JwtCookieName,
-		Value:    token,
-		Path:     "/",
-		Expires:  time.Now().Add(auth.TokenLifeTime),
-		HttpOnly: true,
-	})
-
-	w.WriteHeader(http.StatusOK)
+	writeTokens(w, tokens, reqData.ReturnToken)
 }
 
 // Аутентификация пользователя
@@ -127,8 +126,9 @@ func (h *LoyaltyHandler) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var reqData struct {
-		Login    string `json:"login"`
-		Password string `json:"password"`
+		Login       string `json:"login"`
+		Password    string `json:"password"`
+		ReturnToken bool   `json:"return_token"` // Вернуть токен и в теле ответа
 	}
 
 	if err = json.Unmarshal(body, &reqData); err != nil {
@@ -137,28 +137,25 @@ func (h *LoyaltyHandler) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	//Проверяем пользователя
-	user, err := h.service.GetUser(r.Context(), reqData.Login)
-	if err != nil || user == nil || user.Password != reqData.Password { // В реальном приложении использовать bcrypt!
-		w.WriteHeader(http.StatusUnauthorized)
+	user, err := h.service.Authenticate(r.Context(), reqData.Login, reqData.Password)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		var httpErr *customerrors.HTTPError
+		if errors.As(err, &httpErr) {
+			statusCode = httpErr.Code
+		}
+
+		w.WriteHeader(statusCode)
 		return
 	}
 
-	//Авторизуем пользователя и устанавливаем куки
-	token, err := auth.GenerateToken(user.Login)
+	//Открываем сессию и выдаём токены
+	tokens, err := h.sessions.Start(r.Context(), user.Login)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-
-	http.SetCookie(w, &http.Cookie{
-		Name:     auth.JwtCookieName,
-		Value:    token,
-		Path:     "/",
-		Expires:  time.Now().Add(auth.TokenLifeTime),
-		HttpOnly: true,
-	})
-
-	w.WriteHeader(http.StatusOK)
+	writeTokens(w, tokens, reqData.ReturnToken)
 }
 
 // Получить баланс пользователя
@@ -176,26 +173,146 @@ func (h *LoyaltyHandler) GetBalance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Получение сущностей из сервиса
-	user, err := h.service.GetUser(r.Context(), userID)
+	// Получение баланса из сервиса
+	balance, err := h.service.GetBalance(r.Context(), userID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if user == nil {
+	if balance == nil {
 		//Самая странная ситуация когда пользователь авторизован, но в базе его уже нет
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
 	var respData struct {
-		Current   float32 `json:"current"`
-		Withdrawn float32 `json:"withdrawn"`
+		Current   models.Points `json:"current"`
+		Withdrawn models.Points `json:"withdrawn"`
+		Expired   models.Points `json:"expired"`
 	}
 
-	respData.Current = user.CurrentPoints
-	respData.Withdrawn = user.WithdrawnPoints
+	respData.Current = balance.Current
+	respData.Withdrawn = balance.Withdrawn
+	respData.Expired = balance.Expired
+
+	jsonData, err := json.Marshal(respData)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
+
+// Получить баллы пользователя, которые сгорят в ближайшее время (параметр within, по умолчанию 30 дней)
+func (h *LoyaltyHandler) GetExpiringPoints(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		// разрешаем только Get-запросы
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := customcontext.GetUserID(r.Context())
+	if userID == "" {
+		// UserID в куке пуст
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	within := defaultExpiringWithin
+	if value := r.URL.Query().Get("within"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			http.Error(w, "invalid within", http.StatusBadRequest)
+			return
+		}
+		within = parsed
+	}
+
+	expiring, err := h.service.GetExpiringPoints(r.Context(), userID, within)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type respItem struct {
+		Amount    models.Points `json:"amount"`
+		ExpiresAt time.Time     `json:"expires_at"`
+	}
+
+	var respData struct {
+		Total models.Points `json:"total"`
+		Items []respItem    `json:"items"`
+	}
+
+	respData.Items = make([]respItem, 0, len(expiring))
+	for _, points := range expiring {
+		respData.Total += points.Amount
+		respData.Items = append(respData.Items, respItem{
+			Amount:    points.Amount,
+			ExpiresAt: points.ExpiresAt,
+		})
+	}
+
+	jsonData, err := json.Marshal(respData)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
+
+// Получить уровень пользователя в программе лояльности и продвижение к следующему уровню
+func (h *LoyaltyHandler) GetTier(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		// разрешаем только Get-запросы
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := customcontext.GetUserID(r.Context())
+	if userID == "" {
+		// UserID в куке пуст
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	progress, err := h.service.GetTierProgress(r.Context(), userID)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		var httpErr *customerrors.HTTPError
+		if errors.As(err, &httpErr) {
+			statusCode = httpErr.Code
+		}
+
+		w.WriteHeader(statusCode)
+		return
+	}
+
+	var respData struct {
+		Tier          string         `json:"tier"`
+		Multiplier    models.Points  `json:"multiplier"`
+		Accrued       models.Points  `json:"accrued"`
+		NextTier      string         `json:"next_tier,omitempty"`
+		NextThreshold *models.Points `json:"next_threshold,omitempty"`
+		PointsToNext  *models.Points `json:"points_to_next,omitempty"`
+	}
+
+	respData.Tier = progress.Tier.Name
+	respData.Multiplier = progress.Tier.Multiplier
+	respData.Accrued = progress.Accrued
+	if progress.Next != nil {
+		toNext := max(progress.Next.Threshold-progress.Accrued, 0)
+		respData.NextTier = progress.Next.Name
+		respData.NextThreshold = &progress.Next.Threshold
+		respData.PointsToNext = &toNext
+	}
 
 	jsonData, err := json.Marshal(respData)
 	if err != nil {
@@ -272,28 +389,51 @@ func (h *LoyaltyHandler) GetUserOrders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Фильтры и параметры постраничной выдачи (все необязательные)
+	query := r.URL.Query()
+	page, err := parsePageParams(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	statuses, err := parseStatuses(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	uploaded, err := parseTimeRange(query, "uploaded")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Получение сущностей из сервиса
-	orders, err := h.service.GetUserOrders(r.Context(), userID)
+	orders, err := h.service.GetUserOrders(r.Context(), userID, models.OrderFilter{
+		Statuses: statuses,
+		Uploaded: uploaded,
+		Limit:    page.limit,
+		Cursor:   page.cursor,
+	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if len(orders) == 0 {
+	if len(orders.Items) == 0 && !page.paginated {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
 	type respItem struct {
-		Number     string        `json:"number"`
-		Status     models.Status `json:"status"`
-		Accrual    *float32      `json:"accrual,omitempty"`
-		UploadedAt time.Time     `json:"uploaded_at"`
+		Number     string         `json:"number"`
+		Status     models.Status  `json:"status"`
+		Accrual    *models.Points `json:"accrual,omitempty"`
+		UploadedAt time.Time      `json:"uploaded_at"`
 	}
 
-	var respData []respItem
+	respData := make([]respItem, 0, len(orders.Items))
 
-	for _, order := range orders {
+	for _, order := range orders.Items {
 		item := respItem{
 			Number:     order.Number,
 			Status:     order.Status,
@@ -306,7 +446,12 @@ func (h *LoyaltyHandler) GetUserOrders(w http.ResponseWriter, r *http.Request) {
 		respData = append(respData, item)
 	}
 
-	jsonData, err := json.Marshal(respData)
+	var resp interface{} = respData
+	if page.paginated {
+		resp = pageResponse[respItem]{Items: respData, NextCursor: orders.NextCursor}
+	}
+
+	jsonData, err := json.Marshal(resp)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -347,8 +492,8 @@ func (h *LoyaltyHandler) UploadWithdrawal(w http.ResponseWriter, r *http.Request
 	}
 
 	var reqData struct {
-		Order string  `json:"order"`
-		Sum   float32 `json:"sum"`
+		Order string        `json:"order"`
+		Sum   models.Points `json:"sum"`
 	}
 
 	if err = json.Unmarshal(body, &reqData); err != nil {
@@ -363,10 +508,17 @@ func (h *LoyaltyHandler) UploadWithdrawal(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	//Ключ идемпотентности (необязательный): повтор запроса с тем же ключом не списывает баллы повторно
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if len(idempotencyKey) > maxIdempotencyKeyLen {
+		http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+		return
+	}
+
 	withdrawal := *models.NewWithdrawal(userID, reqData.Order, reqData.Sum)
 
 	//Создаём withdrawal
-	err = h.service.CreateWithdrawal(r.Context(), withdrawal)
+	err = h.service.CreateWithdrawal(r.Context(), withdrawal, idempotencyKey)
 
 	//Определяем статус код
 	statusCode := http.StatusOK
@@ -395,37 +547,66 @@ func (h *LoyaltyHandler) GetUserWithdrawals(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// Фильтры и параметры постраничной выдачи (все необязательные)
+	query := r.URL.Query()
+	page, err := parsePageParams(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	processed, err := parseTimeRange(query, "processed")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Получение сущностей из сервиса
-	withdrawals, err := h.service.GetUserWithdrawals(r.Context(), userID)
+	withdrawals, err := h.service.GetUserWithdrawals(r.Context(), userID, models.WithdrawalFilter{
+		Order:     query.Get("order"),
+		Processed: processed,
+		Limit:     page.limit,
+		Cursor:    page.cursor,
+	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if len(withdrawals) == 0 {
+	if len(withdrawals.Items) == 0 && !page.paginated {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
 	type respItem struct {
-		Order      string    `json:"order"`
-		Sum        float32   `json:"sum"`
-		PocessedAt time.Time `json:"processed_at"`
+		Order          string                  `json:"order"`
+		Sum            models.Points           `json:"sum"`
+		PocessedAt     time.Time               `json:"processed_at"`
+		Status         models.WithdrawalStatus `json:"status"`
+		ReversedAt     *time.Time              `json:"reversed_at,omitempty"`
+		ReversalReason string                  `json:"reversal_reason,omitempty"`
 	}
 
-	var respData []respItem
+	respData := make([]respItem, 0, len(withdrawals.Items))
 
-	for _, withdrawal := range withdrawals {
+	for _, withdrawal := range withdrawals.Items {
 		item := respItem{
-			Order:      withdrawal.Order,
-			Sum:        withdrawal.Sum,
-			PocessedAt: withdrawal.ProcessedAt,
+			Order:          withdrawal.Order,
+			Sum:            withdrawal.Sum,
+			PocessedAt:     withdrawal.ProcessedAt,
+			Status:         withdrawal.Status,
+			ReversedAt:     withdrawal.ReversedAt,
+			ReversalReason: withdrawal.ReversalReason,
 		}
 
 		respData = append(respData, item)
 	}
 
-	jsonData, err := json.Marshal(respData)
+	var resp interface{} = respData
+	if page.paginated {
+		resp = pageResponse[respItem]{Items: respData, NextCursor: withdrawals.NextCursor}
+	}
+
+	jsonData, err := json.Marshal(resp)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/JustScorpio/loyalty_system/internal/customcontext"
+	"github.com/JustScorpio/loyalty_system/internal/customerrors"
+	"github.com/JustScorpio/loyalty_system/internal/services"
+	"github.com/JustScorpio/loyalty_system/internal/utils/auth"
+)
+
+// Путь куки refresh-токена: она нужна только эндпоинтам сессий
+const refreshCookiePath = "/api/user"
+
+// writeTokens выдаёт токен доступа в куке и в заголовке Authorization, refresh-токен - в отдельной куке,
+// а если клиент просил - оба токена и в теле ответа
+func writeTokens(w http.ResponseWriter, tokens *services.TokenPair, inBody bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.JwtCookieName,
+		Value:    tokens.AccessToken,
+		Path:     "/",
+		Expires:  time.Now().Add(tokens.AccessExpiresIn),
+		HttpOnly: true,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.RefreshCookieName,
+		Value:    tokens.RefreshToken,
+		Path:     refreshCookiePath,
+		Expires:  tokens.RefreshExpiresAt,
+		HttpOnly: true,
+	})
+	w.Header().Set("Authorization", auth.BearerPrefix+tokens.AccessToken)
+
+	if !inBody {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	respData := struct {
+		Token        string `json:"token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int64  `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+	}{
+		Token:        tokens.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(tokens.AccessExpiresIn.Seconds()),
+		RefreshToken: tokens.RefreshToken,
+	}
+
+	jsonData, err := json.Marshal(respData)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
+
+// clearTokens удаляет куки с токенами
+func clearTokens(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: auth.JwtCookieName, Path: "/", MaxAge: -1, HttpOnly: true})
+	http.SetCookie(w, &http.Cookie{Name: auth.RefreshCookieName, Path: refreshCookiePath, MaxAge: -1, HttpOnly: true})
+}
+
+// Обновить пару токенов по refresh-токену (из тела запроса или из куки)
+func (h *LoyaltyHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		// разрешаем только POST-запросы
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	//Читаем тело запроса. Клиенты с куками могут прислать пустое тело
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	var reqData struct {
+		RefreshToken string `json:"refresh_token"`
+		ReturnToken  bool   `json:"return_token"`
+	}
+
+	if len(body) > 0 {
+		if err = json.Unmarshal(body, &reqData); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if reqData.RefreshToken == "" {
+		cookie, err := r.Cookie(auth.RefreshCookieName)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		reqData.RefreshToken = cookie.Value
+	}
+
+	tokens, err := h.sessions.Refresh(r.Context(), reqData.RefreshToken)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		var httpErr *customerrors.HTTPError
+		if errors.As(err, &httpErr) {
+			statusCode = httpErr.Code
+		}
+
+		w.WriteHeader(statusCode)
+		return
+	}
+
+	writeTokens(w, tokens, reqData.ReturnToken)
+}
+
+// Завершить текущую сессию
+func (h *LoyaltyHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		// разрешаем только POST-запросы
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := customcontext.GetUserID(r.Context())
+	sessionID := customcontext.GetSessionID(r.Context())
+	if userID == "" || sessionID == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.sessions.Revoke(r.Context(), userID, sessionID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	clearTokens(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+// Завершить все сессии пользователя
+func (h *LoyaltyHandler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		// разрешаем только POST-запросы
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := customcontext.GetUserID(r.Context())
+	if userID == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.sessions.RevokeAll(r.Context(), userID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	clearTokens(w)
+	w.WriteHeader(http.StatusOK)
+}
@@ -1,65 +1,157 @@
 package infrastructure
 
-import "context"
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
 
-type TaskType int
+// ErrDispatcherClosed диспетчер остановлен и новые задачи не принимает
+var ErrDispatcherClosed = errors.New("task dispatcher is closed")
 
-const (
-	TaskCreateUser TaskType = iota
-	TaskGetUser
-	TaskCreateOrder
-	TaskGetUserOrders
-	TaskCreateWithdrawal
-	TaskGetUserWithdrawals
-)
+// TaskFunc задача диспетчера. Типы входных и выходных данных задачи связаны на этапе компиляции
+type TaskFunc[In, Out any] func(ctx context.Context, in In) (Out, error)
 
-type Task struct {
-	Type     TaskType
-	Context  context.Context
-	Payload  interface{}
-	ResultCh chan TaskResult
+// task задача в очереди воркера. Входные данные и канал результата захвачены замыканием run
+type task struct {
+	ctx context.Context
+	run func()
 }
 
-type TaskResult struct {
-	Result interface{}
-	Err    error
+type taskResult[Out any] struct {
+	result Out
+	err    error
 }
 
+// TaskDispatcher пул воркеров. У каждого воркера своя очередь, задача попадает в очередь по хэшу ключа,
+// поэтому задачи одного пользователя выполняются последовательно, а задачи разных пользователей - параллельно
 type TaskDispatcher struct {
-	taskQueue chan Task
+	queues []chan task
+	next   atomic.Uint64 // Счётчик для распределения задач без ключа
+
+	mu      sync.RWMutex // Защищает очереди от закрытия во время постановки задачи
+	closed  bool
+	workers sync.WaitGroup
 }
 
-func NewTaskDispatcher() *TaskDispatcher {
+func NewTaskDispatcher(workers int, queueSize int) *TaskDispatcher {
+	workers = max(workers, 1)
+
+	queues := make([]chan task, workers)
+	for i := range queues {
+		queues[i] = make(chan task, queueSize)
+	}
+
 	return &TaskDispatcher{
-		taskQueue: make(chan Task, 300),
+		queues: queues,
+	}
+}
+
+// queueFor выбирает очередь воркера для задачи
+func (d *TaskDispatcher) queueFor(key string) chan task {
+	if key == "" {
+		return d.queues[d.next.Add(1)%uint64(len(d.queues))]
 	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return d.queues[h.Sum32()%uint32(len(d.queues))]
 }
 
-// Enqueue добавляет задачу в очередь.
-func (d *TaskDispatcher) Enqueue(task Task) (interface{}, error) {
-	if task.ResultCh == nil {
-		task.ResultCh = make(chan TaskResult, 1)
+// Submit ставит задачу fn(ctx, in) в очередь и ждёт её результата. Задачи с одинаковым ключом выполняются
+// строго по очереди (пустой ключ - в любом воркере). Новые виды задач не требуют изменений диспетчера
+func Submit[In, Out any](ctx context.Context, d *TaskDispatcher, key string, fn TaskFunc[In, Out], in In) (Out, error) {
+	var zero Out
+	resultCh := make(chan taskResult[Out], 1)
+
+	t := task{
+		ctx: ctx,
+		run: func() {
+			result, err := fn(ctx, in)
+			resultCh <- taskResult[Out]{result: result, err: err}
+		},
 	}
 
-	d.taskQueue <- task
+	if err := d.enqueue(ctx, key, t); err != nil {
+		return zero, err
+	}
 
 	select {
-	case <-task.Context.Done():
-		return nil, task.Context.Err()
-	case res := <-task.ResultCh:
-		return res.Result, res.Err
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	case res := <-resultCh:
+		return res.result, res.err
 	}
 }
 
-// StartWorker запускает обработчик задач.
-func (d *TaskDispatcher) StartWorker(handler func(Task) (interface{}, error)) {
-	go func() {
-		for task := range d.taskQueue {
-			result, err := handler(task)
-			if task.ResultCh != nil {
-				task.ResultCh <- TaskResult{Result: result, Err: err}
-				close(task.ResultCh)
+// enqueue ставит задачу в очередь воркера
+func (d *TaskDispatcher) enqueue(ctx context.Context, key string, t task) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return ErrDispatcherClosed
+	}
+
+	// Очередь переполнена - ждём, но не дольше, чем живёт запрос
+	select {
+	case d.queueFor(key) <- t:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Exec то же, что Submit, для задач без результата
+func Exec[In any](ctx context.Context, d *TaskDispatcher, key string, fn func(ctx context.Context, in In) error, in In) error {
+	_, err := Submit(ctx, d, key, func(ctx context.Context, in In) (struct{}, error) {
+		return struct{}{}, fn(ctx, in)
+	}, in)
+
+	return err
+}
+
+// StartWorker запускает обработчики задач (по одному на очередь).
+func (d *TaskDispatcher) StartWorker() {
+	for _, queue := range d.queues {
+		d.workers.Add(1)
+		go func() {
+			defer d.workers.Done()
+			for t := range queue {
+				// Запрос уже отменён, пока задача ждала в очереди - результат никто не ждёт
+				if t.ctx.Err() != nil {
+					continue
+				}
+				t.run()
 			}
+		}()
+	}
+}
+
+// Shutdown перестаёт принимать задачи и дожидается выполнения уже поставленных в очередь.
+// Если ctx истекает раньше, возвращает его ошибку, а оставшиеся задачи продолжают выполняться в фоне
+func (d *TaskDispatcher) Shutdown(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, queue := range d.queues {
+			close(queue)
 		}
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.workers.Wait()
+		close(done)
 	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
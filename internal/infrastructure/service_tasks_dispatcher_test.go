@@ -0,0 +1,206 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSameKeyTasksRunInOrder(t *testing.T) {
+	const tasks = 200
+	d := NewTaskDispatcher(8, tasks*2)
+
+	var mu sync.Mutex
+	var order []int
+	var running atomic.Int32
+
+	// Задачи ставятся в очередь до запуска воркеров, вперемешку с задачами других ключей
+	for i := 0; i < tasks; i++ {
+		err := d.enqueue(context.Background(), "user", task{ctx: context.Background(), run: func() {
+			if running.Add(1) != 1 {
+				t.Error("tasks with the same key overlap")
+			}
+			time.Sleep(10 * time.Microsecond)
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			running.Add(-1)
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.enqueue(context.Background(), "other-"+strconv.Itoa(i), task{ctx: context.Background(), run: func() {}})
+	}
+
+	d.StartWorker()
+	if err := d.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(order) != tasks {
+		t.Fatalf("ran %d tasks, want %d", len(order), tasks)
+	}
+	for i, got := range order {
+		if got != i {
+			t.Fatalf("task %d ran at position %d", got, i)
+		}
+	}
+}
+
+func TestSameKeyTasksNeverRunConcurrently(t *testing.T) {
+	d := NewTaskDispatcher(8, 100)
+	d.StartWorker()
+	defer d.Shutdown(context.Background())
+
+	var running, maxRunning atomic.Int32
+	fn := func(ctx context.Context, in int) error {
+		n := running.Add(1)
+		for {
+			current := maxRunning.Load()
+			if n <= current || maxRunning.CompareAndSwap(current, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Microsecond)
+		running.Add(-1)
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := Exec(context.Background(), d, "user", fn, i); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if maxRunning.Load() != 1 {
+		t.Errorf("up to %d tasks of one key ran concurrently", maxRunning.Load())
+	}
+}
+
+func TestCancelledTasksAreSkipped(t *testing.T) {
+	d := NewTaskDispatcher(1, 10)
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	var ran atomic.Bool
+	d.enqueue(cancelledCtx, "user", task{ctx: cancelledCtx, run: func() { ran.Store(true) }})
+	cancel()
+
+	d.StartWorker()
+	if err := d.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if ran.Load() {
+		t.Error("task of a cancelled request was executed")
+	}
+}
+
+func TestSubmitReturnsResult(t *testing.T) {
+	d := NewTaskDispatcher(4, 10)
+	d.StartWorker()
+	defer d.Shutdown(context.Background())
+
+	errTask := errors.New("task failed")
+	got, err := Submit(context.Background(), d, "user", func(ctx context.Context, in int) (string, error) {
+		return fmt.Sprint(in * 2), errTask
+	}, 21)
+
+	if got != "42" || !errors.Is(err, errTask) {
+		t.Errorf("Submit = %q, %v; want \"42\", %v", got, err, errTask)
+	}
+}
+
+func TestSubmitGivesUpWhenRequestIsCancelled(t *testing.T) {
+	// Воркеры не запущены: единственное место в очереди занято, следующая задача ждёт места
+	d := NewTaskDispatcher(1, 1)
+	d.enqueue(context.Background(), "user", task{ctx: context.Background(), run: func() {}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := Exec(ctx, d, "user", func(ctx context.Context, in int) error { return nil }, 0)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Exec into a full queue = %v, want deadline exceeded", err)
+	}
+}
+
+func TestShutdownDrainsQueuedTasks(t *testing.T) {
+	const tasks = 50
+	d := NewTaskDispatcher(4, tasks)
+
+	var done atomic.Int32
+	for i := 0; i < tasks; i++ {
+		d.enqueue(context.Background(), strconv.Itoa(i), task{ctx: context.Background(), run: func() {
+			time.Sleep(time.Millisecond)
+			done.Add(1)
+		}})
+	}
+
+	d.StartWorker()
+	if err := d.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if done.Load() != tasks {
+		t.Errorf("%d of %d queued tasks ran before Shutdown returned", done.Load(), tasks)
+	}
+
+	err := Exec(context.Background(), d, "user", func(ctx context.Context, in int) error { return nil }, 0)
+	if !errors.Is(err, ErrDispatcherClosed) {
+		t.Errorf("Exec after Shutdown = %v, want ErrDispatcherClosed", err)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	d := NewTaskDispatcher(1, 10)
+	release := make(chan struct{})
+	d.enqueue(context.Background(), "user", task{ctx: context.Background(), run: func() { <-release }})
+	d.StartWorker()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := d.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown = %v, want deadline exceeded", err)
+	}
+}
+
+// BenchmarkDispatcher сравнивает пропускную способность одного воркера и шардированного пула на задачах
+// разных пользователей. Задача имитирует обращение к БД
+func BenchmarkDispatcher(b *testing.B) {
+	fn := func(ctx context.Context, in int) error {
+		time.Sleep(100 * time.Microsecond)
+		return nil
+	}
+
+	for _, workers := range []int{1, 4, 16, 64} {
+		b.Run("workers="+strconv.Itoa(workers), func(b *testing.B) {
+			d := NewTaskDispatcher(workers, 300)
+			d.StartWorker()
+			defer d.Shutdown(context.Background())
+
+			var seq atomic.Int64
+			b.SetParallelism(max(64/runtime.GOMAXPROCS(0), 1))
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					key := "user-" + strconv.FormatInt(seq.Add(1), 10)
+					if err := Exec(context.Background(), d, key, fn, 0); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
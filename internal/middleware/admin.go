@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// Заголовок с токеном администратора
+const AdminTokenHeader = "X-Admin-Token"
+
+// AdminMiddleware пропускает только запросы с токеном администратора. Пустой adminToken отключает административные маршруты
+func AdminMiddleware(adminToken string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if adminToken == "" {
+				http.NotFound(w, r)
+				return
+			}
+
+			token := r.Header.Get(AdminTokenHeader)
+			if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
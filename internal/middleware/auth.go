@@ -1,30 +1,65 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/JustScorpio/loyalty_system/internal/customcontext"
+	"github.com/JustScorpio/loyalty_system/internal/customerrors"
 	"github.com/JustScorpio/loyalty_system/internal/utils/auth"
 )
 
-// middleware для добавления и чтения кук.
-func AuthMiddleware() func(http.Handler) http.Handler {
+// ITokenVerifier проверка токена доступа, включая отзыв его сессии
+type ITokenVerifier interface {
+	VerifyAccessToken(ctx context.Context, token string) (*auth.Claims, error)
+}
+
+// middleware для чтения токена. Токен принимается из заголовка Authorization: Bearer <jwt> (для клиентов без кук)
+// или из куки. Если заголовок передан, кука не проверяется
+func AuthMiddleware(verifier ITokenVerifier) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			cookie, err := r.Cookie(auth.JwtCookieName)
-			if err != nil {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
+			token, ok := bearerToken(r)
+			if !ok {
+				cookie, err := r.Cookie(auth.JwtCookieName)
+				if err != nil {
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				token = cookie.Value
 			}
 
-			claims, err := auth.ParseToken(cookie.Value)
+			claims, err := verifier.VerifyAccessToken(r.Context(), token)
 			if err != nil {
-				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				statusCode := http.StatusInternalServerError
+				var httpErr *customerrors.HTTPError
+				if errors.As(err, &httpErr) {
+					statusCode = httpErr.Code
+				}
+
+				http.Error(w, http.StatusText(statusCode), statusCode)
 				return
 			}
 
 			ctx := customcontext.WithUserID(r.Context(), claims.UserID)
+			ctx = customcontext.WithSessionID(ctx, claims.SessionID)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// bearerToken извлекает токен из заголовка Authorization. Схема сравнивается без учёта регистра.
+// Заголовок с другой схемой или без токена считается переданным: такой запрос не проходит проверку
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", false
+	}
+
+	if len(header) < len(auth.BearerPrefix) || !strings.EqualFold(header[:len(auth.BearerPrefix)], auth.BearerPrefix) {
+		return "", true
+	}
+	return strings.TrimSpace(header[len(auth.BearerPrefix):]), true
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+// Campaign промо-акция: дополнительные баллы к начислениям по заказам, загруженным в период акции
+type Campaign struct {
+	ID       int64
+	Name     string
+	StartsAt time.Time // Начало действия (включительно)
+	EndsAt   time.Time // Окончание действия (не включительно)
+	// Множитель начисления по заказу (2 - двойные баллы, 1 - без изменения). Записывается как баллы - в сотых долях
+	Multiplier Points
+	FixedBonus Points // Фиксированный бонус за заказ
+
+	// Условия участия
+	FirstOrderOnly bool     // Только первый обработанный заказ пользователя
+	MinAccrual     Points   // Минимальное начисление по заказу
+	Tiers          []string // Уровни пользователей, которым доступна акция (пусто - всем)
+
+	Active    bool // Отключённые акции не применяются, но остаются в истории бонусов
+	CreatedAt time.Time
+}
+
+// CampaignBonus бонус, начисленный по акции за заказ
+type CampaignBonus struct {
+	CampaignID int64
+	Order      string
+	UserID     string
+	Amount     Points
+	CreatedAt  time.Time
+}
+
+// Validate проверяет настройки акции
+func (c Campaign) Validate() error {
+	switch {
+	case c.Name == "":
+		return errors.New("campaign name is required")
+	case !c.EndsAt.After(c.StartsAt):
+		return errors.New("campaign must end after it starts")
+	case c.Multiplier < pointsScale:
+		return errors.New("campaign multiplier must be at least 1")
+	case c.FixedBonus < 0 || c.MinAccrual < 0:
+		return errors.New("campaign amounts must not be negative")
+	case c.Multiplier == pointsScale && c.FixedBonus == 0:
+		return errors.New("campaign must have a multiplier or a fixed bonus")
+	}
+	return nil
+}
+
+// Eligible проверяет, распространяется ли акция на заказ пользователя уровня tier.
+// firstOrder - заказ первый обработанный у пользователя
+func (c Campaign) Eligible(order Order, tier string, firstOrder bool) bool {
+	switch {
+	case !c.Active:
+		return false
+	case order.UploadedAt.Before(c.StartsAt) || !order.UploadedAt.Before(c.EndsAt):
+		return false
+	case order.Accrual < c.MinAccrual:
+		return false
+	case c.FirstOrderOnly && !firstOrder:
+		return false
+	case len(c.Tiers) > 0 && !slices.Contains(c.Tiers, tier):
+		return false
+	}
+	return true
+}
+
+// Bonus дополнительные баллы акции к начислению accrual (с округлением вниз до сотых)
+func (c Campaign) Bonus(accrual Points) Points {
+	return accrual*(c.Multiplier-pointsScale)/pointsScale + c.FixedBonus
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// IdempotencyKey ключ идемпотентности запроса и результат его первой обработки
+type IdempotencyKey struct {
+	UserID      string
+	Key         string
+	RequestHash string // Хэш тела запроса: тот же ключ с другим запросом отклоняется
+	StatusCode  int    // HTTP-статус первого ответа (0 - запрос ещё обрабатывается)
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
@@ -0,0 +1,103 @@
+package models
+
+import (
+	"strconv"
+	"time"
+)
+
+// Account счёт в журнале баллов. Счета ведутся отдельно для каждого пользователя
+type Account string
+
+const (
+	AccountCurrent     Account = "CURRENT"     // Доступные пользователю баллы
+	AccountWithdrawn   Account = "WITHDRAWN"   // Потраченные пользователем баллы
+	AccountExpired     Account = "EXPIRED"     // Сгоревшие баллы пользователя
+	AccountAccruals    Account = "ACCRUALS"    // Системный счёт - источник начислений
+	AccountAdjustments Account = "ADJUSTMENTS" // Системный счёт - ручные корректировки и входящие остатки
+)
+
+// EntryKind вид операции, породившей проводки
+type EntryKind string
+
+const (
+	EntryAccrual       EntryKind = "ACCRUAL"
+	EntryWithdrawal    EntryKind = "WITHDRAWAL"
+	EntryAdjustment    EntryKind = "ADJUSTMENT"
+	EntryReversal      EntryKind = "REVERSAL"
+	EntryExpiry        EntryKind = "EXPIRY"
+	EntryTierBonus     EntryKind = "TIER_BONUS"
+	EntryCampaignBonus EntryKind = "CAMPAIGN_BONUS"
+)
+
+// LedgerEntry проводка в журнале баллов. Проводки только добавляются, но никогда не изменяются и не удаляются.
+// Все проводки одной операции (TxID) в сумме дают ноль
+type LedgerEntry struct {
+	ID        int64
+	TxID      string
+	UserID    string
+	Account   Account
+	Kind      EntryKind
+	Amount    Points // Положительная сумма - приход на счёт, отрицательная - расход
+	Reference string // Номер заказа (или иной идентификатор), к которому относится операция
+	CreatedAt time.Time
+}
+
+// Balance баланс пользователя
+type Balance struct {
+	Current   Points
+	Withdrawn Points
+	Expired   Points
+}
+
+// BalanceDiscrepancy расхождение между сохранённым в users балансом и рассчитанным по журналу
+type BalanceDiscrepancy struct {
+	UserID   string
+	Snapshot Balance
+	Ledger   Balance
+}
+
+// newTransaction проводки одной операции: amount переносится со счёта from на счёт to
+func newTransaction(kind EntryKind, userID string, reference string, from Account, to Account, amount Points) []LedgerEntry {
+	txID := string(kind) + ":" + reference
+	now := time.Now()
+
+	return []LedgerEntry{
+		{TxID: txID, UserID: userID, Account: from, Kind: kind, Amount: -amount, Reference: reference, CreatedAt: now},
+		{TxID: txID, UserID: userID, Account: to, Kind: kind, Amount: amount, Reference: reference, CreatedAt: now},
+	}
+}
+
+// NewAccrualEntries проводки начисления баллов за заказ
+func NewAccrualEntries(userID string, order string, amount Points) []LedgerEntry {
+	return newTransaction(EntryAccrual, userID, order, AccountAccruals, AccountCurrent, amount)
+}
+
+// NewWithdrawalEntries проводки списания баллов в счёт заказа
+func NewWithdrawalEntries(userID string, order string, amount Points) []LedgerEntry {
+	return newTransaction(EntryWithdrawal, userID, order, AccountCurrent, AccountWithdrawn, amount)
+}
+
+// NewReversalEntries проводки отмены списания: баллы возвращаются на текущий счёт
+func NewReversalEntries(userID string, order string, amount Points) []LedgerEntry {
+	return newTransaction(EntryReversal, userID, order, AccountWithdrawn, AccountCurrent, amount)
+}
+
+// NewTierBonusEntries проводки бонуса уровня к начислению по заказу order
+func NewTierBonusEntries(userID string, order string, amount Points) []LedgerEntry {
+	return newTransaction(EntryTierBonus, userID, order, AccountAccruals, AccountCurrent, amount)
+}
+
+// NewCampaignBonusEntries проводки бонуса по акции campaignID за заказ order
+func NewCampaignBonusEntries(userID string, campaignID int64, order string, amount Points) []LedgerEntry {
+	return newTransaction(EntryCampaignBonus, userID, strconv.FormatInt(campaignID, 10)+":"+order, AccountAccruals, AccountCurrent, amount)
+}
+
+// NewExpiryEntries проводки сгорания баллов. reference должен быть уникальным (например, номер партии баллов)
+func NewExpiryEntries(userID string, reference string, amount Points) []LedgerEntry {
+	return newTransaction(EntryExpiry, userID, reference, AccountCurrent, AccountExpired, amount)
+}
+
+// NewAdjustmentEntries проводки корректировки текущего счёта (amount может быть отрицательным). reference должен быть уникальным
+func NewAdjustmentEntries(userID string, reference string, amount Points) []LedgerEntry {
+	return newTransaction(EntryAdjustment, userID, reference, AccountAdjustments, AccountCurrent, amount)
+}
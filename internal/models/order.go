@@ -7,9 +7,13 @@ import (
 type Order struct {
 	UserID     string
 	Number     string
-	Accrual    float32
+	Accrual    Points
 	Status     Status
 	UploadedAt time.Time
+
+	// Состояние опроса системы начислений
+	NextAttemptAt time.Time // Когда опрашивать систему начислений в следующий раз
+	Attempts      int       // Сколько раз уже опрашивали систему начислений
 }
 
 type Status string
@@ -26,11 +30,14 @@ func (order Order) GetID() string {
 }
 
 func NewOrder(userID string, number string) *Order {
+	now := time.Now()
 	return &Order{
-		UserID:     userID,
-		Number:     number,
-		Accrual:    0,
-		Status:     StatusNew,
-		UploadedAt: time.Now(),
+		UserID:        userID,
+		Number:        number,
+		Accrual:       0,
+		Status:        StatusNew,
+		UploadedAt:    now,
+		NextAttemptAt: now,
+		Attempts:      0,
 	}
 }
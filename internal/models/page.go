@@ -0,0 +1,70 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor позиция в списке, отсортированном от новых к старым: следующая страница начинается с записей
+// старше At (при равном времени - с ключом меньше Key)
+type Cursor struct {
+	At  time.Time
+	Key string
+}
+
+// Encode непрозрачная для клиента строка курсора
+func (c Cursor) Encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.At.Format(time.RFC3339Nano) + "|" + c.Key))
+}
+
+// DecodeCursor разбирает строку, полученную от Cursor.Encode
+func DecodeCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	at, key, ok := strings.Cut(string(raw), "|")
+	if !ok || key == "" {
+		return nil, ErrInvalidCursor
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &Cursor{At: t, Key: key}, nil
+}
+
+// Page страница списка. NextCursor пуст, если страница последняя
+type Page[T any] struct {
+	Items      []T
+	NextCursor string
+}
+
+// Диапазон времени: From включительно, To не включительно. Нулевое значение - без ограничения
+type TimeRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// OrderFilter условия выборки заказов пользователя. Limit 0 - без ограничения
+type OrderFilter struct {
+	Statuses []Status
+	Uploaded TimeRange
+	Limit    int
+	Cursor   *Cursor
+}
+
+// WithdrawalFilter условия выборки списаний пользователя. Limit 0 - без ограничения
+type WithdrawalFilter struct {
+	Order     string
+	Processed TimeRange
+	Limit     int
+	Cursor    *Cursor
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// PointLot партия начисленных баллов. Списания расходуют партии по очереди, начиная с самых старых,
+// неизрасходованный остаток сгорает через заданное время после начисления
+type PointLot struct {
+	ID            int64
+	UserID        string
+	Reference     string // Номер заказа или списания, по которому начислены баллы
+	Amount        Points
+	Remaining     Points // Неизрасходованный остаток
+	CreatedAt     time.Time
+	Expirable     bool       // Сгорает ли партия (входящие остатки не сгорают)
+	ExpiredAt     *time.Time // Когда сгорел остаток
+	ExpiredAmount Points
+}
+
+// ExpiresAt момент сгорания партии при сроке жизни баллов lifetime
+func (lot PointLot) ExpiresAt(lifetime time.Duration) time.Time {
+	return lot.CreatedAt.Add(lifetime)
+}
+
+// ExpiringPoints баллы партии, которые сгорят в момент ExpiresAt, если не будут потрачены раньше
+type ExpiringPoints struct {
+	Amount    Points
+	ExpiresAt time.Time
+}
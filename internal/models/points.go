@@ -0,0 +1,154 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Points количество баллов лояльности в сотых долях (1 балл = 1 рубль = 100 копеек).
+// Хранение в целых числах исключает накопление ошибок округления, присущее float
+type Points int64
+
+// Количество сотых долей в одном балле
+const pointsScale = 100
+
+var errInvalidPoints = errors.New("invalid points amount")
+
+// ParsePoints разбирает десятичную запись ("500", "500.5", "-0.05") без перехода через float.
+// Знаки после второго округляются до сотых по правилу "половина - от нуля"
+func ParsePoints(s string) (Points, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errInvalidPoints
+	}
+
+	negative := false
+	switch s[0] {
+	case '-':
+		negative = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+
+	intPart, fracPart, _ := strings.Cut(s, ".")
+	if intPart == "" && fracPart == "" {
+		return 0, errInvalidPoints
+	}
+	if !isDigits(intPart) || !isDigits(fracPart) {
+		return 0, fmt.Errorf("%w: %q", errInvalidPoints, s)
+	}
+
+	var whole int64
+	if intPart != "" {
+		var err error
+		whole, err = strconv.ParseInt(intPart, 10, 64)
+		if err != nil || whole > (1<<63-1)/pointsScale-1 {
+			return 0, fmt.Errorf("%w: %q", errInvalidPoints, s)
+		}
+	}
+
+	// Дополняем дробную часть до сотых и округляем по третьему знаку
+	frac := fracPart + "000"
+	cents := int64(frac[0]-'0')*10 + int64(frac[1]-'0')
+	if frac[2] >= '5' {
+		cents++
+	}
+
+	value := whole*pointsScale + cents
+	if negative {
+		value = -value
+	}
+
+	return Points(value), nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String десятичная запись без лишних нулей: 500.5, 42, 0.05
+func (p Points) String() string {
+	value := int64(p)
+	sign := ""
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+
+	whole, cents := value/pointsScale, value%pointsScale
+	switch {
+	case cents == 0:
+		return fmt.Sprintf("%s%d", sign, whole)
+	case cents%10 == 0:
+		return fmt.Sprintf("%s%d.%d", sign, whole, cents/10)
+	default:
+		return fmt.Sprintf("%s%d.%02d", sign, whole, cents)
+	}
+}
+
+// MarshalJSON сериализует баллы JSON-числом, как и прежний float32
+func (p Points) MarshalJSON() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalJSON принимает JSON-число (допускается и число в кавычках)
+func (p *Points) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "null" {
+		return nil
+	}
+
+	parsed, err := ParsePoints(s)
+	if err != nil {
+		return err
+	}
+
+	*p = parsed
+	return nil
+}
+
+// Value записывает баллы в NUMERIC с точностью до сотых
+func (p Points) Value() (driver.Value, error) {
+	value := int64(p)
+	sign := ""
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, value/pointsScale, value%pointsScale), nil
+}
+
+// Scan читает баллы из NUMERIC (а также из REAL для ещё не мигрированных данных)
+func (p *Points) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*p = 0
+		return nil
+	case int64:
+		*p = Points(v * pointsScale)
+		return nil
+	case float64:
+		parsed, err := ParsePoints(strconv.FormatFloat(v, 'f', -1, 64))
+		*p = parsed
+		return err
+	case string:
+		parsed, err := ParsePoints(v)
+		*p = parsed
+		return err
+	case []byte:
+		parsed, err := ParsePoints(string(v))
+		*p = parsed
+		return err
+	}
+
+	return fmt.Errorf("cannot scan %T into Points", src)
+}
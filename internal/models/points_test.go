@@ -0,0 +1,156 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParsePoints(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Points
+		wantErr bool
+	}{
+		{input: "500", want: 50000},
+		{input: "500.5", want: 50050},
+		{input: "0.05", want: 5},
+		{input: "-0.05", want: -5},
+		{input: "+1.25", want: 125},
+		{input: ".5", want: 50},
+		{input: "7.", want: 700},
+		{input: " 42 ", want: 4200},
+		// Третий знак округляется по правилу "половина - от нуля"
+		{input: "0.005", want: 1},
+		{input: "0.004", want: 0},
+		{input: "0.995", want: 100},
+		{input: "-0.005", want: -1},
+		{input: "729.98", want: 72998},
+		{input: "1e3", wantErr: true},
+		{input: ".", wantErr: true},
+		{input: "", wantErr: true},
+		{input: "-", wantErr: true},
+		{input: "1.2.3", wantErr: true},
+		{input: "--1", wantErr: true},
+		{input: "abc", wantErr: true},
+		{input: "92233720368547758", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParsePoints(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParsePoints(%q) = %v, want error", tt.input, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePoints(%q) unexpected error: %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParsePoints(%q) = %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPointsStringAndJSON(t *testing.T) {
+	tests := []struct {
+		points Points
+		want   string
+	}{
+		{0, "0"},
+		{50000, "500"},
+		{50050, "500.5"},
+		{5, "0.05"},
+		{-5, "-0.05"},
+		{72998, "729.98"},
+		{-12340, "-123.4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.points.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+
+			data, err := json.Marshal(tt.points)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("MarshalJSON() = %s, want %s", data, tt.want)
+			}
+
+			var decoded Points
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if decoded != tt.points {
+				t.Errorf("round-trip %s = %d, want %d", data, decoded, tt.points)
+			}
+		})
+	}
+}
+
+func TestPointsUnmarshalQuotedAndNull(t *testing.T) {
+	var value struct {
+		Sum     Points  `json:"sum"`
+		Accrual *Points `json:"accrual"`
+	}
+	if err := json.Unmarshal([]byte(`{"sum":"751.5","accrual":null}`), &value); err != nil {
+		t.Fatal(err)
+	}
+	if value.Sum != 75150 || value.Accrual != nil {
+		t.Errorf("got %+v", value)
+	}
+}
+
+func TestPointsScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     interface{}
+		want    Points
+		wantErr bool
+	}{
+		{name: "nil", src: nil, want: 0},
+		{name: "int64", src: int64(42), want: 4200},
+		{name: "float64", src: float64(0.1), want: 10},
+		{name: "float64 from real", src: float64(float32(729.98)), want: 72998},
+		{name: "string", src: "500.50", want: 50050},
+		{name: "bytes", src: []byte("-0.05"), want: -5},
+		{name: "invalid string", src: "abc", wantErr: true},
+		{name: "unsupported type", src: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Points
+			err := got.Scan(tt.src)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Scan(%v) = %v, want error", tt.src, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Scan(%v) unexpected error: %v", tt.src, err)
+			}
+			if got != tt.want {
+				t.Errorf("Scan(%v) = %d, want %d", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPointsValue(t *testing.T) {
+	for points, want := range map[Points]string{0: "0.00", 50050: "500.50", -5: "-0.05"} {
+		got, err := points.Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Value(%d) = %v, want %s", points, got, want)
+		}
+	}
+}
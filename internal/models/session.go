@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Session сессия пользователя: пара из короткоживущего токена доступа и refresh-токена.
+// Отзыв сессии делает недействительными оба токена
+type Session struct {
+	ID          string
+	UserID      string
+	RefreshHash string // Хэш текущего refresh-токена (меняется при каждом обновлении)
+	CreatedAt   time.Time
+	ExpiresAt   time.Time // Срок действия refresh-токена
+	RevokedAt   *time.Time
+}
+
+func (session Session) GetID() string {
+	return session.ID
+}
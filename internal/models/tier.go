@@ -0,0 +1,117 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Tier уровень программы лояльности. Уровень определяется суммой баллов, начисленных за скользящее окно
+type Tier struct {
+	Name      string
+	Threshold Points // Сколько баллов нужно начислить за окно, чтобы получить уровень
+	// Множитель начислений (1.5 - в полтора раза больше). Записывается как баллы - в сотых долях
+	Multiplier Points
+}
+
+// Bonus дополнительные баллы уровня к начислению accrual (с округлением вниз до сотых)
+func (t Tier) Bonus(accrual Points) Points {
+	return accrual * (t.Multiplier - pointsScale) / pointsScale
+}
+
+// Tiers уровни программы лояльности по возрастанию порога. Первый уровень - начальный, его порог нулевой
+type Tiers []Tier
+
+// ParseTiers разбирает список уровней вида "BRONZE:0:1,SILVER:5000:1.05,GOLD:20000:1.1" (имя:порог:множитель).
+// Пустая строка - уровни не используются
+func ParseTiers(s string) (Tiers, error) {
+	var tiers Tiers
+	if strings.TrimSpace(s) == "" {
+		return tiers, nil
+	}
+
+	names := make(map[string]bool)
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ":")
+		if len(fields) != 3 || fields[0] == "" {
+			return nil, fmt.Errorf("invalid tier %q: expected name:threshold:multiplier", part)
+		}
+
+		name := strings.ToUpper(fields[0])
+		if names[name] {
+			return nil, fmt.Errorf("duplicate tier %q", name)
+		}
+		names[name] = true
+
+		threshold, err := ParsePoints(fields[1])
+		if err != nil || threshold < 0 {
+			return nil, fmt.Errorf("invalid threshold of tier %q", name)
+		}
+		multiplier, err := ParsePoints(fields[2])
+		if err != nil || multiplier < pointsScale {
+			return nil, fmt.Errorf("invalid multiplier of tier %q: must be at least 1", name)
+		}
+
+		tiers = append(tiers, Tier{Name: name, Threshold: threshold, Multiplier: multiplier})
+	}
+
+	sort.SliceStable(tiers, func(i, j int) bool { return tiers[i].Threshold < tiers[j].Threshold })
+	if tiers[0].Threshold != 0 {
+		return nil, errors.New("threshold of the lowest tier must be 0")
+	}
+	for i := 1; i < len(tiers); i++ {
+		if tiers[i].Threshold == tiers[i-1].Threshold {
+			return nil, fmt.Errorf("tiers %q and %q have the same threshold", tiers[i-1].Name, tiers[i].Name)
+		}
+	}
+
+	return tiers, nil
+}
+
+// ForPoints уровень, которого достигает пользователь, начисливший accrued баллов за окно
+func (t Tiers) ForPoints(accrued Points) Tier {
+	tier := t[0]
+	for _, candidate := range t[1:] {
+		if candidate.Threshold > accrued {
+			break
+		}
+		tier = candidate
+	}
+	return tier
+}
+
+// Lookup уровень по имени. Неизвестное имя (уровень ещё не рассчитан или удалён из настроек) - начальный уровень
+func (t Tiers) Lookup(name string) Tier {
+	for _, tier := range t {
+		if tier.Name == name {
+			return tier
+		}
+	}
+	return t[0]
+}
+
+// Next уровень, следующий за уровнем name. false - уровень name максимальный
+func (t Tiers) Next(name string) (Tier, bool) {
+	current := t.Lookup(name)
+	for i, tier := range t[:len(t)-1] {
+		if tier.Name == current.Name {
+			return t[i+1], true
+		}
+	}
+	return Tier{}, false
+}
+
+// TierChange смена уровня пользователя при пересчёте
+type TierChange struct {
+	UserID string
+	From   string
+	To     string
+}
+
+// TierProgress текущий уровень пользователя и продвижение к следующему
+type TierProgress struct {
+	Tier    Tier
+	Accrued Points // Начислено за скользящее окно
+	Next    *Tier  // nil - уровень максимальный
+}
@@ -3,8 +3,10 @@ package models
 type User struct {
 	Login           string
 	Password        string
-	CurrentPoints   float32
-	WithdrawnPoints float32
+	CurrentPoints   Points
+	WithdrawnPoints Points
+	ExpiredPoints   Points // Сгоревшие баллы
+	Tier            string // Уровень программы лояльности (пусто - ещё не рассчитан)
 }
 
 func (user User) GetID() string {
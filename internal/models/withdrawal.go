@@ -5,19 +5,33 @@ import "time"
 type Withdrawal struct {
 	UserID      string
 	Order       string
-	Sum         float32
+	Sum         Points
 	ProcessedAt time.Time
+	Status      WithdrawalStatus
+
+	// Отмена списания (возврат баллов)
+	ReversedAt     *time.Time
+	ReversalReason string
 }
 
+// WithdrawalStatus статус списания
+type WithdrawalStatus string
+
+const (
+	WithdrawalProcessed WithdrawalStatus = "PROCESSED" // Баллы списаны
+	WithdrawalReversed  WithdrawalStatus = "REVERSED"  // Списание отменено, баллы возвращены
+)
+
 func (order Withdrawal) GetID() string {
 	return order.Order
 }
 
-func NewWithdrawal(userID string, order string, sum float32) *Withdrawal {
+func NewWithdrawal(userID string, order string, sum Points) *Withdrawal {
 	return &Withdrawal{
 		UserID:      userID,
 		Order:       order,
 		Sum:         sum,
 		ProcessedAt: time.Now(),
+		Status:      WithdrawalProcessed,
 	}
 }
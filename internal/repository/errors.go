@@ -0,0 +1,15 @@
+package repository
+
+import "errors"
+
+// ErrAlreadyExists - запись с таким идентификатором уже есть (нарушение уникальности)
+var ErrAlreadyExists = errors.New("entity already exists")
+
+// ErrInsufficientFunds - списание увело бы баланс пользователя в минус
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// ErrAlreadyReversed - операция уже отменена
+var ErrAlreadyReversed = errors.New("already reversed")
+
+// ErrNotFound - запись не найдена
+var ErrNotFound = errors.New("entity not found")
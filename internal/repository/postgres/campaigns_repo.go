@@ -0,0 +1,111 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/JustScorpio/loyalty_system/internal/models"
+	"github.com/JustScorpio/loyalty_system/internal/repository"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Колонки акции в порядке полей campaignFields
+const campaignColumns = "id, name, startsat, endsat, multiplier, fixedbonus, firstorderonly, minaccrual, tiers, active, createdat"
+
+func campaignFields(campaign *models.Campaign) []interface{} {
+	return []interface{}{&campaign.ID, &campaign.Name, &campaign.StartsAt, &campaign.EndsAt, &campaign.Multiplier, &campaign.FixedBonus,
+		&campaign.FirstOrderOnly, &campaign.MinAccrual, &campaign.Tiers, &campaign.Active, &campaign.CreatedAt}
+}
+
+type PgCampaignsRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewPgCampaignsRepo(db *pgxpool.Pool) *PgCampaignsRepo {
+	return &PgCampaignsRepo{db: db}
+}
+
+func (r *PgCampaignsRepo) GetAll(ctx context.Context) ([]models.Campaign, error) {
+	return r.query(ctx, "SELECT "+campaignColumns+" FROM campaigns ORDER BY startsat DESC, id DESC")
+}
+
+func (r *PgCampaignsRepo) Get(ctx context.Context, id int64) (*models.Campaign, error) {
+	var campaign models.Campaign
+	err := conn(ctx, r.db).QueryRow(ctx, "SELECT "+campaignColumns+" FROM campaigns WHERE id = $1", id).Scan(campaignFields(&campaign)...)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &campaign, nil
+}
+
+func (r *PgCampaignsRepo) Create(ctx context.Context, campaign *models.Campaign) error {
+	return conn(ctx, r.db).QueryRow(ctx, `
+		INSERT INTO campaigns (name, startsat, endsat, multiplier, fixedbonus, firstorderonly, minaccrual, tiers, active, createdat)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`,
+		campaign.Name, campaign.StartsAt, campaign.EndsAt, campaign.Multiplier, campaign.FixedBonus,
+		campaign.FirstOrderOnly, campaign.MinAccrual, tiersArray(campaign.Tiers), campaign.Active, campaign.CreatedAt).Scan(&campaign.ID)
+}
+
+func (r *PgCampaignsRepo) Update(ctx context.Context, campaign *models.Campaign) error {
+	tag, err := conn(ctx, r.db).Exec(ctx, `
+		UPDATE campaigns SET name = $2, startsat = $3, endsat = $4, multiplier = $5, fixedbonus = $6, firstorderonly = $7,
+			minaccrual = $8, tiers = $9, active = $10
+		WHERE id = $1`,
+		campaign.ID, campaign.Name, campaign.StartsAt, campaign.EndsAt, campaign.Multiplier, campaign.FixedBonus,
+		campaign.FirstOrderOnly, campaign.MinAccrual, tiersArray(campaign.Tiers), campaign.Active)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+func (r *PgCampaignsRepo) GetActive(ctx context.Context, at time.Time) ([]models.Campaign, error) {
+	return r.query(ctx, "SELECT "+campaignColumns+" FROM campaigns WHERE active AND startsat <= $1 AND endsat > $1 ORDER BY id", at)
+}
+
+func (r *PgCampaignsRepo) AddBonus(ctx context.Context, bonus *models.CampaignBonus) error {
+	_, err := conn(ctx, r.db).Exec(ctx, "INSERT INTO campaign_bonuses (campaignid, \"order\", userid, amount, createdat) VALUES ($1, $2, $3, $4, $5)",
+		bonus.CampaignID, bonus.Order, bonus.UserID, bonus.Amount, bonus.CreatedAt)
+	if isUniqueViolation(err) {
+		return repository.ErrAlreadyExists
+	}
+	return err
+}
+
+func (r *PgCampaignsRepo) query(ctx context.Context, query string, args ...interface{}) ([]models.Campaign, error) {
+	rows, err := conn(ctx, r.db).Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var campaigns []models.Campaign
+	for rows.Next() {
+		var campaign models.Campaign
+		err := rows.Scan(campaignFields(&campaign)...)
+		if err != nil {
+			return nil, err
+		}
+		campaigns = append(campaigns, campaign)
+	}
+
+	return campaigns, rows.Err()
+}
+
+// tiersArray колонка tiers не допускает NULL, а nil-срез pgx записывает как NULL
+func tiersArray(tiers []string) []string {
+	if tiers == nil {
+		return []string{}
+	}
+	return tiers
+}
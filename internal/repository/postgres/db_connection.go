@@ -3,22 +3,129 @@ package postgres
 import (
 	"context"
 	"fmt"
+	"time"
 
+	"github.com/JustScorpio/loyalty_system/internal/customcontext"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func NewDBConnection(connStr string) (*pgx.Conn, error) {
+// PoolConfig настройки пула соединений. Нулевые значения - значения pgxpool по умолчанию
+type PoolConfig struct {
+	MaxConns          int32         // Максимальное количество соединений
+	MinConns          int32         // Количество соединений, которые держатся открытыми всегда
+	MaxConnLifetime   time.Duration // Через сколько соединение пересоздаётся
+	MaxConnIdleTime   time.Duration // Через сколько простаивающее соединение закрывается
+	HealthCheckPeriod time.Duration // Как часто проверять простаивающие соединения
+	ConnectTimeout    time.Duration // Таймаут установки соединения
+}
+
+func NewDBPool(connStr string, poolConfig PoolConfig) (*pgxpool.Pool, error) {
+	config, err := pgxpool.ParseConfig(connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse connection string: %w", err)
+	}
+
+	if poolConfig.MaxConns > 0 {
+		config.MaxConns = poolConfig.MaxConns
+	}
+	if poolConfig.MinConns > 0 {
+		config.MinConns = poolConfig.MinConns
+	}
+	if poolConfig.MaxConnLifetime > 0 {
+		config.MaxConnLifetime = poolConfig.MaxConnLifetime
+	}
+	if poolConfig.MaxConnIdleTime > 0 {
+		config.MaxConnIdleTime = poolConfig.MaxConnIdleTime
+	}
+	if poolConfig.HealthCheckPeriod > 0 {
+		config.HealthCheckPeriod = poolConfig.HealthCheckPeriod
+	}
+	if poolConfig.ConnectTimeout > 0 {
+		config.ConnConfig.ConnectTimeout = poolConfig.ConnectTimeout
+	}
 
 	// Подключение к базе данных
-	db, err := pgx.Connect(context.Background(), connStr)
+	db, err := pgxpool.NewWithConfig(context.Background(), config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
 	//Проверка подключения
 	if err = db.Ping(context.Background()); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
 	return db, nil
 }
+
+// querier общие методы пула и транзакции
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// conn возвращает транзакцию из контекста, если она есть, иначе пул.
+// Внутри транзакции все запросы репозиториев должны идти через одно её соединение
+func conn(ctx context.Context, db *pgxpool.Pool) querier {
+	if tx, ok := customcontext.GetTx(ctx); ok {
+		return tx
+	}
+	return db
+}
+
+// PoolStats состояние пула соединений
+type PoolStats struct {
+	Healthy                 bool  `json:"healthy"`
+	TotalConns              int32 `json:"total_conns"`
+	AcquiredConns           int32 `json:"acquired_conns"`
+	IdleConns               int32 `json:"idle_conns"`
+	ConstructingConns       int32 `json:"constructing_conns"`
+	MaxConns                int32 `json:"max_conns"`
+	AcquireCount            int64 `json:"acquire_count"`
+	EmptyAcquireCount       int64 `json:"empty_acquire_count"`
+	CanceledAcquireCount    int64 `json:"canceled_acquire_count"`
+	AcquireDurationMs       int64 `json:"acquire_duration_ms"`
+	NewConnsCount           int64 `json:"new_conns_count"`
+	MaxLifetimeDestroyCount int64 `json:"max_lifetime_destroy_count"`
+	MaxIdleDestroyCount     int64 `json:"max_idle_destroy_count"`
+}
+
+// PoolHealth проверка доступности БД и статистика пула
+type PoolHealth struct {
+	db *pgxpool.Pool
+}
+
+func NewPoolHealth(db *pgxpool.Pool) *PoolHealth {
+	return &PoolHealth{db: db}
+}
+
+// Health пингует БД и возвращает статистику пула. Ошибка означает, что БД недоступна
+func (h *PoolHealth) Health(ctx context.Context) (interface{}, error) {
+	stat := h.db.Stat()
+	stats := PoolStats{
+		Healthy:                 true,
+		TotalConns:              stat.TotalConns(),
+		AcquiredConns:           stat.AcquiredConns(),
+		IdleConns:               stat.IdleConns(),
+		ConstructingConns:       stat.ConstructingConns(),
+		MaxConns:                stat.MaxConns(),
+		AcquireCount:            stat.AcquireCount(),
+		EmptyAcquireCount:       stat.EmptyAcquireCount(),
+		CanceledAcquireCount:    stat.CanceledAcquireCount(),
+		AcquireDurationMs:       stat.AcquireDuration().Milliseconds(),
+		NewConnsCount:           stat.NewConnsCount(),
+		MaxLifetimeDestroyCount: stat.MaxLifetimeDestroyCount(),
+		MaxIdleDestroyCount:     stat.MaxIdleDestroyCount(),
+	}
+
+	if err := h.db.Ping(ctx); err != nil {
+		stats.Healthy = false
+		return stats, err
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,60 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/JustScorpio/loyalty_system/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PgIdempotencyRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewPgIdempotencyRepo(db *pgxpool.Pool) *PgIdempotencyRepo {
+	return &PgIdempotencyRepo{db: db}
+}
+
+func (r *PgIdempotencyRepo) Acquire(ctx context.Context, key *models.IdempotencyKey) (*models.IdempotencyKey, error) {
+	// ON CONFLICT DO UPDATE блокирует существующую строку даже если условие WHERE не выполнено:
+	// параллельный запрос с тем же ключом ждёт завершения первого и затем видит его результат
+	var inserted bool
+	err := conn(ctx, r.db).QueryRow(ctx, `
+		INSERT INTO idempotency_keys (userid, key, requesthash, statuscode, createdat, expiresat) VALUES ($1, $2, $3, NULL, $4, $5)
+		ON CONFLICT (userid, key) DO UPDATE SET requesthash = EXCLUDED.requesthash, statuscode = NULL, createdat = EXCLUDED.createdat, expiresat = EXCLUDED.expiresat
+		WHERE idempotency_keys.expiresat <= EXCLUDED.createdat
+		RETURNING true`,
+		key.UserID, key.Key, key.RequestHash, key.CreatedAt, key.ExpiresAt).Scan(&inserted)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	var existing models.IdempotencyKey
+	var statusCode *int
+	err = conn(ctx, r.db).QueryRow(ctx, "SELECT userid, key, requesthash, statuscode, createdat, expiresat FROM idempotency_keys WHERE userid = $1 AND key = $2 FOR UPDATE", key.UserID, key.Key).
+		Scan(&existing.UserID, &existing.Key, &existing.RequestHash, &statusCode, &existing.CreatedAt, &existing.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if statusCode != nil {
+		existing.StatusCode = *statusCode
+	}
+
+	return &existing, nil
+}
+
+func (r *PgIdempotencyRepo) Complete(ctx context.Context, userID string, key string, statusCode int) error {
+	_, err := conn(ctx, r.db).Exec(ctx, "UPDATE idempotency_keys SET statuscode = $3 WHERE userid = $1 AND key = $2", userID, key, statusCode)
+	return err
+}
+
+func (r *PgIdempotencyRepo) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	tag, err := conn(ctx, r.db).Exec(ctx, "DELETE FROM idempotency_keys WHERE expiresat <= $1", now)
+	return tag.RowsAffected(), err
+}
@@ -0,0 +1,158 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/JustScorpio/loyalty_system/internal/customcontext"
+	"github.com/JustScorpio/loyalty_system/internal/models"
+	"github.com/JustScorpio/loyalty_system/internal/repository"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PgLedgerRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewPgLedgerRepo(db *pgxpool.Pool) *PgLedgerRepo {
+	return &PgLedgerRepo{db: db}
+}
+
+func (r *PgLedgerRepo) Post(ctx context.Context, entries []models.LedgerEntry) error {
+	// Проводки и обновление сохранённого баланса должны попасть в БД вместе
+	tx, ok := customcontext.GetTx(ctx)
+	if !ok {
+		return errors.New("ledger entries must be posted inside a transaction")
+	}
+
+	// Каждая операция должна быть сбалансирована
+	sums := make(map[string]models.Points)
+	deltas := make(map[string]*models.Balance)
+	for _, entry := range entries {
+		sums[entry.TxID] += entry.Amount
+
+		delta, ok := deltas[entry.UserID]
+		if !ok {
+			delta = &models.Balance{}
+			deltas[entry.UserID] = delta
+		}
+		switch entry.Account {
+		case models.AccountCurrent:
+			delta.Current += entry.Amount
+		case models.AccountWithdrawn:
+			delta.Withdrawn += entry.Amount
+		case models.AccountExpired:
+			delta.Expired += entry.Amount
+		}
+	}
+	for txID, sum := range sums {
+		if sum != 0 {
+			return fmt.Errorf("unbalanced ledger transaction %s: %v", txID, sum)
+		}
+	}
+
+	// Последняя добавленная проводка каждого пользователя - до неё журнал учтён в снимке баланса
+	lastEntryIDs := make(map[string]int64)
+	for _, entry := range entries {
+		var id int64
+		err := tx.QueryRow(ctx, "INSERT INTO ledger (txid, userid, account, kind, amount, reference, createdat) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id", entry.TxID, entry.UserID, entry.Account, entry.Kind, entry.Amount, entry.Reference, entry.CreatedAt).Scan(&id)
+		if isUniqueViolation(err) {
+			return fmt.Errorf("ledger transaction %s is already posted: %w", entry.TxID, repository.ErrAlreadyExists)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to post ledger entry %s: %w", entry.TxID, err)
+		}
+		lastEntryIDs[entry.UserID] = max(lastEntryIDs[entry.UserID], id)
+	}
+
+	// Сохранённый баланс (снимок) - приращениями, без чтения текущего значения. Условие на остаток проверяется
+	// под блокировкой строки, поэтому параллельные списания не могут увести баланс в минус
+	for userID, delta := range deltas {
+		tag, err := tx.Exec(ctx, `
+			UPDATE users SET currentpoints = COALESCE(currentpoints, 0) + $2, withdrawnpoints = COALESCE(withdrawnpoints, 0) + $3,
+				expiredpoints = expiredpoints + $4, ledgerentryid = GREATEST(ledgerentryid, $5)
+			WHERE login = $1 AND COALESCE(currentpoints, 0) + $2 >= 0`, userID, delta.Current, delta.Withdrawn, delta.Expired, lastEntryIDs[userID])
+		if err != nil {
+			return fmt.Errorf("failed to update balance snapshot: %w", err)
+		}
+		if tag.RowsAffected() == 0 {
+			return repository.ErrInsufficientFunds
+		}
+	}
+
+	return nil
+}
+
+func (r *PgLedgerRepo) GetBalance(ctx context.Context, userID string) (*models.Balance, int64, error) {
+	// Снимок обновляется в одной транзакции с проводками, поэтому учитывает все проводки до ledgerentryid.
+	// Досчитываются только более поздние проводки - в исправном журнале их нет
+	var balance models.Balance
+	var unaccounted int64
+	err := conn(ctx, r.db).QueryRow(ctx, `
+		SELECT COALESCE(u.currentpoints, 0) + COALESCE(t.current, 0), COALESCE(u.withdrawnpoints, 0) + COALESCE(t.withdrawn, 0),
+			u.expiredpoints + COALESCE(t.expired, 0), t.entries
+		FROM users u
+		CROSS JOIN LATERAL (
+			SELECT SUM(amount) FILTER (WHERE account = $2) AS current,
+				SUM(amount) FILTER (WHERE account = $3) AS withdrawn,
+				SUM(amount) FILTER (WHERE account = $4) AS expired,
+				COUNT(*) AS entries
+			FROM ledger l WHERE l.userid = u.login AND l.id > u.ledgerentryid
+		) t
+		WHERE u.login = $1`, userID, models.AccountCurrent, models.AccountWithdrawn, models.AccountExpired).Scan(&balance.Current, &balance.Withdrawn, &balance.Expired, &unaccounted)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, 0, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return &balance, unaccounted, nil
+}
+
+func (r *PgLedgerRepo) GetAccruedSince(ctx context.Context, userID string, since time.Time) (models.Points, error) {
+	// Вид проводки и счёт - литералами, под частичный индекс ledger_accruals_idx
+	var accrued models.Points
+	err := conn(ctx, r.db).QueryRow(ctx, `
+		SELECT COALESCE(SUM(amount), 0) FROM ledger
+		WHERE userid = $1 AND kind = 'ACCRUAL' AND account = 'CURRENT' AND createdat >= $2`, userID, since).Scan(&accrued)
+
+	return accrued, err
+}
+
+func (r *PgLedgerRepo) FindDiscrepancies(ctx context.Context) ([]models.BalanceDiscrepancy, error) {
+	rows, err := conn(ctx, r.db).Query(ctx, `
+		SELECT u.login, COALESCE(u.currentpoints, 0), COALESCE(u.withdrawnpoints, 0), u.expiredpoints,
+			COALESCE(l.current, 0), COALESCE(l.withdrawn, 0), COALESCE(l.expired, 0)
+		FROM users u
+		LEFT JOIN (
+			SELECT userid,
+				SUM(amount) FILTER (WHERE account = $1) AS current,
+				SUM(amount) FILTER (WHERE account = $2) AS withdrawn,
+				SUM(amount) FILTER (WHERE account = $3) AS expired
+			FROM ledger GROUP BY userid
+		) l ON l.userid = u.login
+		WHERE COALESCE(u.currentpoints, 0) <> COALESCE(l.current, 0) OR COALESCE(u.withdrawnpoints, 0) <> COALESCE(l.withdrawn, 0)
+			OR u.expiredpoints <> COALESCE(l.expired, 0)`,
+		models.AccountCurrent, models.AccountWithdrawn, models.AccountExpired)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var discrepancies []models.BalanceDiscrepancy
+	for rows.Next() {
+		var d models.BalanceDiscrepancy
+		err := rows.Scan(&d.UserID, &d.Snapshot.Current, &d.Snapshot.Withdrawn, &d.Snapshot.Expired, &d.Ledger.Current, &d.Ledger.Withdrawn, &d.Ledger.Expired)
+		if err != nil {
+			return nil, err
+		}
+		discrepancies = append(discrepancies, d)
+	}
+
+	return discrepancies, rows.Err()
+}
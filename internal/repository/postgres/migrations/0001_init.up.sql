@@ -0,0 +1,51 @@
+-- Базовая схема. Написана идемпотентно: в базах, созданных до появления миграций, таблицы уже существуют
+CREATE TABLE IF NOT EXISTS users (
+	login TEXT NOT NULL PRIMARY KEY,
+	password TEXT,
+	currentpoints NUMERIC(14, 2),
+	withdrawnpoints NUMERIC(14, 2)
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+	userid TEXT NOT NULL,
+	number TEXT NOT NULL PRIMARY KEY,
+	accrual NUMERIC(14, 2),
+	status TEXT,
+	uploadedat TIMESTAMP,
+	nextattemptat TIMESTAMP NOT NULL DEFAULT now(),
+	attempts INTEGER NOT NULL DEFAULT 0,
+	leaseowner TEXT,
+	leaseuntil TIMESTAMP
+);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS nextattemptat TIMESTAMP NOT NULL DEFAULT now();
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS leaseowner TEXT;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS leaseuntil TIMESTAMP;
+CREATE INDEX IF NOT EXISTS orders_pending_idx ON orders (nextattemptat) WHERE status IN ('NEW', 'PROCESSING');
+
+CREATE TABLE IF NOT EXISTS withdrawals (
+	userid TEXT NOT NULL,
+	"order" TEXT NOT NULL PRIMARY KEY,
+	sum NUMERIC(14, 2),
+	processedat TIMESTAMP
+);
+
+-- Перевод сумм из REAL в NUMERIC (точные суммы до копейки)
+DO $$
+BEGIN
+	IF (SELECT data_type FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'currentpoints') = 'real' THEN
+		ALTER TABLE users
+			ALTER COLUMN currentpoints TYPE NUMERIC(14, 2) USING round(currentpoints::numeric, 2),
+			ALTER COLUMN withdrawnpoints TYPE NUMERIC(14, 2) USING round(withdrawnpoints::numeric, 2);
+	END IF;
+	IF (SELECT data_type FROM information_schema.columns WHERE table_name = 'orders' AND column_name = 'accrual') = 'real' THEN
+		ALTER TABLE orders ALTER COLUMN accrual TYPE NUMERIC(14, 2) USING round(accrual::numeric, 2);
+	END IF;
+	IF (SELECT data_type FROM information_schema.columns WHERE table_name = 'withdrawals' AND column_name = 'sum') = 'real' THEN
+		ALTER TABLE withdrawals ALTER COLUMN sum TYPE NUMERIC(14, 2) USING round(sum::numeric, 2);
+	END IF;
+END $$;
+
+-- Исправление статусов, записанных напрямую из системы начислений (REGISTERED и прочие неизвестные значения)
+UPDATE orders SET status = 'PROCESSING' WHERE status = 'REGISTERED';
+UPDATE orders SET status = 'NEW' WHERE status IS NULL OR status NOT IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED');
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS ledger;
+DROP FUNCTION IF EXISTS ledger_immutable();
@@ -0,0 +1,35 @@
+-- Журнал баллов. Изменение и удаление проводок запрещено триггером
+CREATE TABLE IF NOT EXISTS ledger (
+	id BIGSERIAL PRIMARY KEY,
+	txid TEXT NOT NULL,
+	userid TEXT NOT NULL,
+	account TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	amount NUMERIC(14, 2) NOT NULL,
+	reference TEXT NOT NULL,
+	createdat TIMESTAMP NOT NULL DEFAULT now(),
+	UNIQUE (txid, account)
+);
+CREATE INDEX IF NOT EXISTS ledger_userid_idx ON ledger (userid, account);
+
+CREATE OR REPLACE FUNCTION ledger_immutable() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'ledger entries are immutable';
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_immutable ON ledger;
+CREATE TRIGGER ledger_immutable BEFORE UPDATE OR DELETE ON ledger FOR EACH ROW EXECUTE FUNCTION ledger_immutable();
+
+-- Входящие остатки для пользователей, чьи балансы появились до введения журнала
+INSERT INTO ledger (txid, userid, account, kind, amount, reference)
+SELECT 'ADJUSTMENT:OPENING:' || u.login, u.login, a.account, 'ADJUSTMENT', a.amount, 'OPENING:' || u.login
+FROM users u
+CROSS JOIN LATERAL (VALUES
+	('CURRENT', COALESCE(u.currentpoints, 0)),
+	('WITHDRAWN', COALESCE(u.withdrawnpoints, 0)),
+	('ADJUSTMENTS', -(COALESCE(u.currentpoints, 0) + COALESCE(u.withdrawnpoints, 0)))
+) AS a(account, amount)
+WHERE (COALESCE(u.currentpoints, 0) <> 0 OR COALESCE(u.withdrawnpoints, 0) <> 0)
+	AND NOT EXISTS (SELECT 1 FROM ledger l WHERE l.userid = u.login)
+ON CONFLICT (txid, account) DO NOTHING;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS withdrawals_userid_idx;
+DROP INDEX IF EXISTS orders_userid_idx;
@@ -0,0 +1,3 @@
+-- Индексы для выборки заказов и списаний одного пользователя
+CREATE INDEX IF NOT EXISTS orders_userid_idx ON orders (userid, uploadedat DESC);
+CREATE INDEX IF NOT EXISTS withdrawals_userid_idx ON withdrawals (userid, processedat DESC);
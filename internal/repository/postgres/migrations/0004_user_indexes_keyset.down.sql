@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS orders_userid_idx;
+CREATE INDEX orders_userid_idx ON orders (userid, uploadedat DESC);
+DROP INDEX IF EXISTS withdrawals_userid_idx;
+CREATE INDEX withdrawals_userid_idx ON withdrawals (userid, processedat DESC);
@@ -0,0 +1,5 @@
+-- Постраничная выдача сортирует по времени и номеру заказа - добавляем номер в индексы
+DROP INDEX IF EXISTS orders_userid_idx;
+CREATE INDEX orders_userid_idx ON orders (userid, uploadedat DESC, number DESC);
+DROP INDEX IF EXISTS withdrawals_userid_idx;
+CREATE INDEX withdrawals_userid_idx ON withdrawals (userid, processedat DESC, "order" DESC);
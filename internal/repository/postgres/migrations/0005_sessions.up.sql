@@ -0,0 +1,10 @@
+-- Сессии пользователей. Хранится только хэш refresh-токена
+CREATE TABLE IF NOT EXISTS sessions (
+	id TEXT NOT NULL PRIMARY KEY,
+	userid TEXT NOT NULL,
+	refreshhash TEXT NOT NULL UNIQUE,
+	createdat TIMESTAMP NOT NULL DEFAULT now(),
+	expiresat TIMESTAMP NOT NULL,
+	revokedat TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS sessions_userid_idx ON sessions (userid) WHERE revokedat IS NULL;
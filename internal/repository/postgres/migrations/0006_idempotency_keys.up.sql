@@ -0,0 +1,11 @@
+-- Ключи идемпотентности запросов и статусы ответов на них
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	userid TEXT NOT NULL,
+	key TEXT NOT NULL,
+	requesthash TEXT NOT NULL,
+	statuscode INTEGER,
+	createdat TIMESTAMP NOT NULL,
+	expiresat TIMESTAMP NOT NULL,
+	PRIMARY KEY (userid, key)
+);
+CREATE INDEX IF NOT EXISTS idempotency_keys_expiresat_idx ON idempotency_keys (expiresat);
@@ -0,0 +1,3 @@
+ALTER TABLE withdrawals DROP COLUMN IF EXISTS reversalreason;
+ALTER TABLE withdrawals DROP COLUMN IF EXISTS reversedat;
+ALTER TABLE withdrawals DROP COLUMN IF EXISTS status;
@@ -0,0 +1,4 @@
+-- Статус списания и сведения об его отмене
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'PROCESSED';
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS reversedat TIMESTAMP;
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS reversalreason TEXT;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS point_lots;
+ALTER TABLE users DROP COLUMN IF EXISTS expiredpoints;
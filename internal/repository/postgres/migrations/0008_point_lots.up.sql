@@ -0,0 +1,40 @@
+-- Сгоревшие баллы пользователя
+ALTER TABLE users ADD COLUMN IF NOT EXISTS expiredpoints NUMERIC(14, 2) NOT NULL DEFAULT 0;
+
+-- Партии начисленных баллов. Списания расходуют партии по очереди (FIFO), остаток партии сгорает по истечении срока
+CREATE TABLE IF NOT EXISTS point_lots (
+	id BIGSERIAL PRIMARY KEY,
+	userid TEXT NOT NULL,
+	reference TEXT NOT NULL,
+	amount NUMERIC(14, 2) NOT NULL,
+	remaining NUMERIC(14, 2) NOT NULL,
+	createdat TIMESTAMP NOT NULL,
+	expirable BOOLEAN NOT NULL DEFAULT true,
+	expiredat TIMESTAMP,
+	expiredamount NUMERIC(14, 2) NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS point_lots_userid_idx ON point_lots (userid, createdat) WHERE remaining > 0;
+CREATE INDEX IF NOT EXISTS point_lots_createdat_idx ON point_lots (createdat) WHERE remaining > 0 AND expirable;
+
+-- Партии для уже начисленных баллов: потраченное считается израсходованным из самых старых начислений
+INSERT INTO point_lots (userid, reference, amount, remaining, createdat)
+SELECT o.userid, o.number, o.accrual,
+	LEAST(o.accrual, GREATEST(0, o.cumulative - GREATEST(0, o.accrued - COALESCE(u.currentpoints, 0)))),
+	COALESCE(o.uploadedat, now())
+FROM (
+	SELECT userid, number, accrual, uploadedat,
+		SUM(accrual) OVER (PARTITION BY userid ORDER BY uploadedat, number) AS cumulative,
+		SUM(accrual) OVER (PARTITION BY userid) AS accrued
+	FROM orders
+	WHERE status = 'PROCESSED' AND accrual > 0
+) o
+JOIN users u ON u.login = o.userid
+WHERE NOT EXISTS (SELECT 1 FROM point_lots l WHERE l.userid = o.userid);
+
+-- Баллы сверх начисленных (входящие остатки, корректировки) не сгорают
+INSERT INTO point_lots (userid, reference, amount, remaining, createdat, expirable)
+SELECT u.login, 'OPENING', u.currentpoints - COALESCE(o.accrued, 0), u.currentpoints - COALESCE(o.accrued, 0), now(), false
+FROM users u
+LEFT JOIN (SELECT userid, SUM(accrual) AS accrued FROM orders WHERE status = 'PROCESSED' AND accrual > 0 GROUP BY userid) o ON o.userid = u.login
+WHERE COALESCE(u.currentpoints, 0) > COALESCE(o.accrued, 0)
+	AND NOT EXISTS (SELECT 1 FROM point_lots l WHERE l.userid = u.login AND l.reference = 'OPENING');
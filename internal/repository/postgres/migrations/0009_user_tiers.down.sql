@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS ledger_accruals_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS tierupdatedat;
+ALTER TABLE users DROP COLUMN IF EXISTS tier;
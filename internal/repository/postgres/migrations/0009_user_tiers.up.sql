@@ -0,0 +1,6 @@
+-- Уровень программы лояльности пользователя (пусто - ещё не рассчитан)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tier TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tierupdatedat TIMESTAMP;
+
+-- Начисления за скользящее окно, по которым рассчитывается уровень
+CREATE INDEX IF NOT EXISTS ledger_accruals_idx ON ledger (userid, createdat) WHERE kind = 'ACCRUAL' AND account = 'CURRENT';
@@ -0,0 +1,26 @@
+-- Промо-акции
+CREATE TABLE IF NOT EXISTS campaigns (
+	id BIGSERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	startsat TIMESTAMP NOT NULL,
+	endsat TIMESTAMP NOT NULL,
+	multiplier NUMERIC(14, 2) NOT NULL DEFAULT 1,
+	fixedbonus NUMERIC(14, 2) NOT NULL DEFAULT 0,
+	firstorderonly BOOLEAN NOT NULL DEFAULT false,
+	minaccrual NUMERIC(14, 2) NOT NULL DEFAULT 0,
+	tiers TEXT[] NOT NULL DEFAULT '{}',
+	active BOOLEAN NOT NULL DEFAULT true,
+	createdat TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS campaigns_period_idx ON campaigns (startsat, endsat) WHERE active;
+
+-- Бонусы, начисленные по акциям. Бонус по одной акции за один заказ начисляется один раз
+CREATE TABLE IF NOT EXISTS campaign_bonuses (
+	campaignid BIGINT NOT NULL REFERENCES campaigns (id),
+	"order" TEXT NOT NULL,
+	userid TEXT NOT NULL,
+	amount NUMERIC(14, 2) NOT NULL,
+	createdat TIMESTAMP NOT NULL,
+	PRIMARY KEY (campaignid, "order")
+);
+CREATE INDEX IF NOT EXISTS campaign_bonuses_userid_idx ON campaign_bonuses (userid);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS ledger_userid_id_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS ledgerentryid;
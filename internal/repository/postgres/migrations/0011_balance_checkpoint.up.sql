@@ -0,0 +1,6 @@
+-- Последняя проводка журнала, учтённая в сохранённом балансе пользователя. Баланс рассчитывается по журналу:
+-- сохранённый баланс плюс проводки пользователя после этой
+ALTER TABLE users ADD COLUMN IF NOT EXISTS ledgerentryid BIGINT NOT NULL DEFAULT 0;
+UPDATE users u SET ledgerentryid = COALESCE((SELECT MAX(l.id) FROM ledger l WHERE l.userid = u.login), 0);
+
+CREATE INDEX IF NOT EXISTS ledger_userid_id_idx ON ledger (userid, id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS sessions_expiresat_idx;
+DROP TABLE IF EXISTS session_used_tokens;
@@ -0,0 +1,8 @@
+-- Хэши уже обновлённых refresh-токенов. Повторное предъявление такого токена означает, что он украден:
+-- по нему находится сессия, которую нужно отозвать
+CREATE TABLE IF NOT EXISTS session_used_tokens (
+	refreshhash TEXT NOT NULL PRIMARY KEY,
+	sessionid TEXT NOT NULL REFERENCES sessions (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS session_used_tokens_sessionid_idx ON session_used_tokens (sessionid);
+CREATE INDEX IF NOT EXISTS sessions_expiresat_idx ON sessions (expiresat);
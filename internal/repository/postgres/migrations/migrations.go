@@ -0,0 +1,209 @@
+// Package migrations версионированные миграции схемы БД. SQL-файлы вида NNNN_name.up.sql / NNNN_name.down.sql
+// встраиваются в бинарник, применённые версии хранятся в таблице schema_migrations
+package migrations
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// Ключ advisory-блокировки: миграции не выполняются параллельно несколькими экземплярами сервиса
+const lockKey = 7243001
+
+// Migration одна версия схемы
+type Migration struct {
+	Version int64
+	Name    string
+	up      string
+	down    string
+}
+
+// load читает встроенные миграции, отсортированные по версии
+func load() ([]Migration, error) {
+	entries, err := fs.Glob(files, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, file := range entries {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(file, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name %q", file)
+		}
+		versionStr, name, _ := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(versionStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %q: %w", file, err)
+		}
+
+		content, err := files.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.up = string(content)
+		} else {
+			m.down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("migration %d has no up script", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// withLock выполняет fn на отдельном соединении под advisory-блокировкой
+func withLock(ctx context.Context, db *pgxpool.Pool, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+		return fmt.Errorf("failed to acquire migrations lock: %w", err)
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey)
+
+	_, err = conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT NOT NULL PRIMARY KEY,
+			name TEXT NOT NULL,
+			appliedat TIMESTAMP NOT NULL DEFAULT now()
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	return fn(conn)
+}
+
+func currentVersion(ctx context.Context, conn *pgxpool.Conn) (int64, error) {
+	var version int64
+	err := conn.QueryRow(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version, err
+}
+
+// apply выполняет скрипт миграции и изменяет schema_migrations в одной транзакции
+func apply(ctx context.Context, conn *pgxpool.Conn, script string, record func(tx pgx.Tx) error) error {
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, script); err != nil {
+			return err
+		}
+		return record(tx)
+	})
+}
+
+// Up применяет все ещё не применённые миграции. Возвращает номер версии схемы после применения
+func Up(ctx context.Context, db *pgxpool.Pool) (int64, error) {
+	migrations, err := load()
+	if err != nil {
+		return 0, err
+	}
+
+	var version int64
+	err = withLock(ctx, db, func(conn *pgxpool.Conn) error {
+		version, err = currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if m.Version <= version {
+				continue
+			}
+
+			err := apply(ctx, conn, m.up, func(tx pgx.Tx) error {
+				_, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s failed: %w", m.Version, m.Name, err)
+			}
+			version = m.Version
+		}
+		return nil
+	})
+
+	return version, err
+}
+
+// Down откатывает steps последних применённых миграций. Возвращает номер версии схемы после отката
+func Down(ctx context.Context, db *pgxpool.Pool, steps int) (int64, error) {
+	migrations, err := load()
+	if err != nil {
+		return 0, err
+	}
+
+	var version int64
+	err = withLock(ctx, db, func(conn *pgxpool.Conn) error {
+		version, err = currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if m.Version > version {
+				continue
+			}
+			if m.down == "" {
+				return fmt.Errorf("migration %d_%s cannot be reverted: no down script", m.Version, m.Name)
+			}
+
+			err := apply(ctx, conn, m.down, func(tx pgx.Tx) error {
+				_, err := tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("rollback of migration %d_%s failed: %w", m.Version, m.Name, err)
+			}
+
+			steps--
+			version, err = currentVersion(ctx, conn)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return version, err
+}
+
+// Version текущая версия схемы (0 - миграции не применялись)
+func Version(ctx context.Context, db *pgxpool.Pool) (int64, error) {
+	var version int64
+	err := withLock(ctx, db, func(conn *pgxpool.Conn) error {
+		var err error
+		version, err = currentVersion(ctx, conn)
+		return err
+	})
+	return version, err
+}
@@ -2,37 +2,23 @@ package postgres
 
 import (
 	"context"
-	"fmt"
+	"time"
 
-	"github.com/JustScorpio/loyalty_system/internal/customcontext"
 	"github.com/JustScorpio/loyalty_system/internal/models"
-	"github.com/jackc/pgx/v5"
+	"github.com/JustScorpio/loyalty_system/internal/repository"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type PgOrdersRepo struct {
-	db *pgx.Conn
+	db *pgxpool.Pool
 }
 
-func NewPgOrdersRepo(db *pgx.Conn) (*PgOrdersRepo, error) {
-	// Создание таблицы orders, если её нет
-	_, err := db.Exec(context.Background(), `
-		CREATE TABLE IF NOT EXISTS orders (
-			userid TEXT NOT NULL,
-			number TEXT NOT NULL PRIMARY KEY,
-			accrual REAL,
-			status TEXT,
-			uploadedat TIMESTAMP
-		);
-	`)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create tables: %w", err)
-	}
-
-	return &PgOrdersRepo{db: db}, nil
+func NewPgOrdersRepo(db *pgxpool.Pool) *PgOrdersRepo {
+	return &PgOrdersRepo{db: db}
 }
 
 func (r *PgOrdersRepo) GetAll(ctx context.Context) ([]models.Order, error) {
-	rows, err := r.db.Query(ctx, "SELECT userid, number, accrual, status, uploadedat FROM orders")
+	rows, err := conn(ctx, r.db).Query(ctx, "SELECT userid, number, accrual, status, uploadedat, nextattemptat, attempts FROM orders")
 	if err != nil {
 		return nil, err
 	}
@@ -46,7 +32,7 @@ func (r *PgOrdersRepo) GetAll(ctx context.Context) ([]models.Order, error) {
 	var orders []models.Order
 	for rows.Next() {
 		var order models.Order
-		err := rows.Scan(&order.UserID, &order.Number, &order.Accrual, &order.Status, &order.UploadedAt)
+		err := rows.Scan(&order.UserID, &order.Number, &order.Accrual, &order.Status, &order.UploadedAt, &order.NextAttemptAt, &order.Attempts)
 		if err != nil {
 			return nil, err
 		}
@@ -58,7 +44,7 @@ func (r *PgOrdersRepo) GetAll(ctx context.Context) ([]models.Order, error) {
 
 func (r *PgOrdersRepo) Get(ctx context.Context, number string) (*models.Order, error) {
 	var order models.Order
-	err := r.db.QueryRow(ctx, "SELECT userid, number, accrual, status, uploadedat FROM orders WHERE number = $1", number).Scan(&order.UserID, &order.Number, &order.Accrual, &order.Status, &order.UploadedAt)
+	err := conn(ctx, r.db).QueryRow(ctx, "SELECT userid, number, accrual, status, uploadedat, nextattemptat, attempts FROM orders WHERE number = $1", number).Scan(&order.UserID, &order.Number, &order.Accrual, &order.Status, &order.UploadedAt, &order.NextAttemptAt, &order.Attempts)
 
 	if err != nil {
 		return nil, err
@@ -66,8 +52,96 @@ func (r *PgOrdersRepo) Get(ctx context.Context, number string) (*models.Order, e
 	return &order, nil
 }
 
+func (r *PgOrdersRepo) GetByUser(ctx context.Context, userID string, filter models.OrderFilter) ([]models.Order, error) {
+	// Запрос обслуживается индексом orders_userid_idx
+	var b queryBuilder
+	b.where("userid = " + b.arg(userID))
+	if len(filter.Statuses) > 0 {
+		statuses := make([]string, len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			statuses[i] = string(status)
+		}
+		b.where("status = ANY(" + b.arg(statuses) + ")")
+	}
+	b.timeRange("uploadedat", filter.Uploaded)
+	b.keyset("uploadedat", "number", filter.Cursor)
+
+	query := b.build("SELECT userid, number, accrual, status, uploadedat, nextattemptat, attempts FROM orders", "uploadedat DESC, number DESC", filter.Limit)
+	rows, err := conn(ctx, r.db).Query(ctx, query, b.args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var orders []models.Order
+	for rows.Next() {
+		var order models.Order
+		err := rows.Scan(&order.UserID, &order.Number, &order.Accrual, &order.Status, &order.UploadedAt, &order.NextAttemptAt, &order.Attempts)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+
+	return orders, rows.Err()
+}
+
+func (r *PgOrdersRepo) ClaimPending(ctx context.Context, owner string, now time.Time, leaseUntil time.Time, limit int) ([]models.Order, error) {
+	// SKIP LOCKED - строки, которые прямо сейчас захватывает другой экземпляр, пропускаются без ожидания
+	rows, err := conn(ctx, r.db).Query(ctx, `
+		UPDATE orders SET leaseowner = $1, leaseuntil = $2
+		WHERE number IN (
+			SELECT number FROM orders
+			WHERE status IN ($3, $4) AND nextattemptat <= $5 AND (leaseuntil IS NULL OR leaseuntil <= $5)
+			ORDER BY nextattemptat
+			LIMIT $6
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING userid, number, accrual, status, uploadedat, nextattemptat, attempts`,
+		owner, leaseUntil, models.StatusNew, models.StatusProcessing, now, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var orders []models.Order
+	for rows.Next() {
+		var order models.Order
+		err := rows.Scan(&order.UserID, &order.Number, &order.Accrual, &order.Status, &order.UploadedAt, &order.NextAttemptAt, &order.Attempts)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+
+	return orders, rows.Err()
+}
+
+func (r *PgOrdersRepo) UpdatePending(ctx context.Context, owner string, order *models.Order) (bool, error) {
+	// Условие на статус берёт блокировку строки: параллельный апдейт дождётся коммита и увидит уже финальный статус.
+	// Условие на владельца не даёт экземпляру, у которого истёк захват, затереть захват и расписание нового владельца
+	query := `
+		UPDATE orders SET accrual = $2, status = $3, nextattemptat = $4, attempts = $5, leaseowner = NULL, leaseuntil = NULL
+		WHERE number = $1 AND status IN ($6, $7) AND leaseowner = $8`
+	args := []interface{}{order.Number, order.Accrual, order.Status, order.NextAttemptAt, order.Attempts, models.StatusNew, models.StatusProcessing, owner}
+
+	tag, err := conn(ctx, r.db).Exec(ctx, query, args...)
+	return tag.RowsAffected() == 1, err
+}
+
+func (r *PgOrdersRepo) HasProcessed(ctx context.Context, userID string, except string) (bool, error) {
+	var exists bool
+	err := conn(ctx, r.db).QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM orders WHERE userid = $1 AND status = $2 AND number <> $3)", userID, models.StatusProcessed, except).Scan(&exists)
+	return exists, err
+}
+
 func (r *PgOrdersRepo) Create(ctx context.Context, order *models.Order) error {
-	err := r.execQuery(ctx, "INSERT INTO orders (userid, number, accrual, status, uploadedat) VALUES ($1, $2, $3, $4, $5)", order.UserID, order.Number, order.Accrual, order.Status, order.UploadedAt)
+	err := r.execQuery(ctx, "INSERT INTO orders (userid, number, accrual, status, uploadedat, nextattemptat, attempts) VALUES ($1, $2, $3, $4, $5, $6, $7)", order.UserID, order.Number, order.Accrual, order.Status, order.UploadedAt, order.NextAttemptAt, order.Attempts)
+	if isUniqueViolation(err) {
+		return repository.ErrAlreadyExists
+	}
 	if err != nil {
 		return err
 	}
@@ -75,7 +149,7 @@ func (r *PgOrdersRepo) Create(ctx context.Context, order *models.Order) error {
 }
 
 func (r *PgOrdersRepo) Update(ctx context.Context, order *models.Order) error {
-	err := r.execQuery(ctx, "UPDATE orders SET userid = $1, number = $2, accrual = $3, status = $4, uploadedat = $5 WHERE number = $2", order.UserID, order.Number, order.Accrual, order.Status, order.UploadedAt)
+	err := r.execQuery(ctx, "UPDATE orders SET userid = $1, number = $2, accrual = $3, status = $4, uploadedat = $5, nextattemptat = $6, attempts = $7 WHERE number = $2", order.UserID, order.Number, order.Accrual, order.Status, order.UploadedAt, order.NextAttemptAt, order.Attempts)
 	return err
 }
 
@@ -91,10 +165,6 @@ func (r *PgOrdersRepo) PingDB() bool {
 
 // execQuery выполняет запрос, автоматически используя транзакцию из контекста если она есть
 func (r *PgOrdersRepo) execQuery(ctx context.Context, query string, args ...interface{}) error {
-	if tx, ok := customcontext.GetTx(ctx); ok {
-		_, err := tx.Exec(ctx, query, args...)
-		return err
-	}
-	_, err := r.db.Exec(ctx, query, args...)
+	_, err := conn(ctx, r.db).Exec(ctx, query, args...)
 	return err
 }
@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/JustScorpio/loyalty_system/internal/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Колонки партии в порядке полей pointLotFields
+const pointLotColumns = "id, userid, reference, amount, remaining, createdat, expirable, expiredat, expiredamount"
+
+func pointLotFields(lot *models.PointLot) []interface{} {
+	return []interface{}{&lot.ID, &lot.UserID, &lot.Reference, &lot.Amount, &lot.Remaining, &lot.CreatedAt, &lot.Expirable, &lot.ExpiredAt, &lot.ExpiredAmount}
+}
+
+type PgPointLotsRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewPgPointLotsRepo(db *pgxpool.Pool) *PgPointLotsRepo {
+	return &PgPointLotsRepo{db: db}
+}
+
+func (r *PgPointLotsRepo) Add(ctx context.Context, lot *models.PointLot) error {
+	return conn(ctx, r.db).QueryRow(ctx, "INSERT INTO point_lots (userid, reference, amount, remaining, createdat, expirable) VALUES ($1, $2, $3, $3, $4, $5) RETURNING id",
+		lot.UserID, lot.Reference, lot.Amount, lot.CreatedAt, lot.Expirable).Scan(&lot.ID)
+}
+
+func (r *PgPointLotsRepo) Consume(ctx context.Context, userID string, amount models.Points) error {
+	lots, err := r.query(ctx, "SELECT "+pointLotColumns+" FROM point_lots WHERE userid = $1 AND remaining > 0 ORDER BY expirable DESC, createdat, id FOR UPDATE", userID)
+	if err != nil {
+		return err
+	}
+
+	// Остаток на балансе проверен проводкой списания. Если партий не хватило (баллы начислены в обход партий),
+	// недостающая часть просто не привязывается к партиям
+	for _, lot := range lots {
+		if amount <= 0 {
+			break
+		}
+		consumed := min(lot.Remaining, amount)
+		_, err := conn(ctx, r.db).Exec(ctx, "UPDATE point_lots SET remaining = remaining - $2 WHERE id = $1", lot.ID, consumed)
+		if err != nil {
+			return err
+		}
+		amount -= consumed
+	}
+
+	return nil
+}
+
+func (r *PgPointLotsRepo) FindUsersWithExpired(ctx context.Context, createdBefore time.Time, after string, limit int) ([]string, error) {
+	rows, err := conn(ctx, r.db).Query(ctx, "SELECT DISTINCT userid FROM point_lots WHERE expirable AND remaining > 0 AND createdat < $1 AND userid > $2 ORDER BY userid LIMIT $3", createdBefore, after, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var users []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		users = append(users, userID)
+	}
+
+	return users, rows.Err()
+}
+
+func (r *PgPointLotsRepo) ClaimExpired(ctx context.Context, userID string, createdBefore time.Time) ([]models.PointLot, error) {
+	return r.query(ctx, "SELECT "+pointLotColumns+" FROM point_lots WHERE userid = $1 AND expirable AND remaining > 0 AND createdat < $2 ORDER BY createdat, id FOR UPDATE SKIP LOCKED", userID, createdBefore)
+}
+
+func (r *PgPointLotsRepo) MarkExpired(ctx context.Context, id int64, amount models.Points, at time.Time) error {
+	_, err := conn(ctx, r.db).Exec(ctx, "UPDATE point_lots SET expiredamount = expiredamount + $2, remaining = 0, expiredat = $3 WHERE id = $1", id, amount, at)
+	return err
+}
+
+func (r *PgPointLotsRepo) GetExpiring(ctx context.Context, userID string, createdBefore time.Time) ([]models.PointLot, error) {
+	return r.query(ctx, "SELECT "+pointLotColumns+" FROM point_lots WHERE userid = $1 AND expirable AND remaining > 0 AND createdat < $2 ORDER BY createdat, id", userID, createdBefore)
+}
+
+func (r *PgPointLotsRepo) query(ctx context.Context, query string, args ...interface{}) ([]models.PointLot, error) {
+	rows, err := conn(ctx, r.db).Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var lots []models.PointLot
+	for rows.Next() {
+		var lot models.PointLot
+		err := rows.Scan(pointLotFields(&lot)...)
+		if err != nil {
+			return nil, err
+		}
+		lots = append(lots, lot)
+	}
+
+	return lots, rows.Err()
+}
@@ -0,0 +1,57 @@
+package postgres
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/JustScorpio/loyalty_system/internal/models"
+)
+
+// queryBuilder собирает условия WHERE с позиционными параметрами ($1, $2, ...)
+type queryBuilder struct {
+	conds []string
+	args  []interface{}
+}
+
+// arg добавляет параметр запроса и возвращает его плейсхолдер
+func (b *queryBuilder) arg(value interface{}) string {
+	b.args = append(b.args, value)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+func (b *queryBuilder) where(cond string) {
+	b.conds = append(b.conds, cond)
+}
+
+// timeRange условие на диапазон времени: начало включительно, конец - нет.
+// Колонки TIMESTAMP хранят местное время сервера (time.Now()), а pgx передаёт в них время без пояса,
+// поэтому границы с любым часовым поясом переводятся в местное время
+func (b *queryBuilder) timeRange(column string, r models.TimeRange) {
+	if !r.From.IsZero() {
+		b.where(column + " >= " + b.arg(r.From.In(time.Local)))
+	}
+	if !r.To.IsZero() {
+		b.where(column + " < " + b.arg(r.To.In(time.Local)))
+	}
+}
+
+// keyset условие "после курсора" для сортировки timeColumn DESC, keyColumn DESC
+func (b *queryBuilder) keyset(timeColumn string, keyColumn string, cursor *models.Cursor) {
+	if cursor == nil {
+		return
+	}
+	b.where(fmt.Sprintf("(%s, %s) < (%s, %s)", timeColumn, keyColumn, b.arg(cursor.At), b.arg(cursor.Key)))
+}
+
+// build дописывает к запросу select условия, сортировку и ограничение количества строк (0 - без ограничения)
+func (b *queryBuilder) build(query string, orderBy string, limit int) string {
+	if len(b.conds) > 0 {
+		query += " WHERE " + strings.Join(b.conds, " AND ")
+	}
+	query += " ORDER BY " + orderBy
+	if limit > 0 {
+		query += " LIMIT " + b.arg(limit)
+	}
+	return query
+}
@@ -0,0 +1,37 @@
+package postgres
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JustScorpio/loyalty_system/internal/models"
+)
+
+func TestTimeRangeUsesServerLocalTime(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("UTC+3", 3*60*60)
+	defer func() { time.Local = local }()
+
+	// Граница с поясом +05:00 соответствует 10:00 по местному времени сервера (UTC+3)
+	from, err := time.Parse(time.RFC3339, "2024-03-01T12:00:00+05:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	var b queryBuilder
+	b.timeRange("uploadedat", models.TimeRange{From: from, To: to})
+
+	if len(b.args) != 2 {
+		t.Fatalf("got %d query args, want 2", len(b.args))
+	}
+
+	wantWallClock := []string{"2024-03-01 10:00:00", "2024-03-01 12:00:00"}
+	for i, arg := range b.args {
+		bound := arg.(time.Time)
+		// В колонку TIMESTAMP pgx записывает только показания часов, без пояса
+		if got := bound.Format(time.DateTime); got != wantWallClock[i] {
+			t.Errorf("bound %d is %s on the wall clock, want %s", i, got, wantWallClock[i])
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/JustScorpio/loyalty_system/internal/models"
+	"github.com/JustScorpio/loyalty_system/internal/repository"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PgSessionsRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewPgSessionsRepo(db *pgxpool.Pool) *PgSessionsRepo {
+	return &PgSessionsRepo{db: db}
+}
+
+func (r *PgSessionsRepo) Create(ctx context.Context, session *models.Session) error {
+	_, err := conn(ctx, r.db).Exec(ctx, "INSERT INTO sessions (id, userid, refreshhash, createdat, expiresat) VALUES ($1, $2, $3, $4, $5)", session.ID, session.UserID, session.RefreshHash, session.CreatedAt, session.ExpiresAt)
+	if isUniqueViolation(err) {
+		return repository.ErrAlreadyExists
+	}
+	return err
+}
+
+func (r *PgSessionsRepo) Rotate(ctx context.Context, oldHash string, newHash string, now time.Time, expiresAt time.Time) (*models.Session, error) {
+	// Условный UPDATE: из двух параллельных обновлений одним токеном успешно только одно.
+	// Старый токен запоминается в той же команде - его повторное предъявление распознаётся как кража
+	var session models.Session
+	err := conn(ctx, r.db).QueryRow(ctx, `
+		WITH rotated AS (
+			UPDATE sessions SET refreshhash = $2, expiresat = $4
+			WHERE refreshhash = $1 AND revokedat IS NULL AND expiresat > $3
+			RETURNING id, userid, refreshhash, createdat, expiresat, revokedat
+		), used AS (
+			INSERT INTO session_used_tokens (refreshhash, sessionid) SELECT $1, id FROM rotated
+		)
+		SELECT id, userid, refreshhash, createdat, expiresat, revokedat FROM rotated`,
+		oldHash, newHash, now, expiresAt).Scan(&session.ID, &session.UserID, &session.RefreshHash, &session.CreatedAt, &session.ExpiresAt, &session.RevokedAt)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *PgSessionsRepo) IsActive(ctx context.Context, id string, now time.Time) (bool, error) {
+	var active bool
+	err := conn(ctx, r.db).QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM sessions WHERE id = $1 AND revokedat IS NULL AND expiresat > $2)", id, now).Scan(&active)
+	return active, err
+}
+
+func (r *PgSessionsRepo) Revoke(ctx context.Context, userID string, id string, now time.Time) error {
+	_, err := conn(ctx, r.db).Exec(ctx, "UPDATE sessions SET revokedat = $3 WHERE id = $1 AND userid = $2 AND revokedat IS NULL", id, userID, now)
+	return err
+}
+
+func (r *PgSessionsRepo) RevokeAll(ctx context.Context, userID string, now time.Time) error {
+	_, err := conn(ctx, r.db).Exec(ctx, "UPDATE sessions SET revokedat = $2 WHERE userid = $1 AND revokedat IS NULL", userID, now)
+	return err
+}
+
+func (r *PgSessionsRepo) RevokeByUsedToken(ctx context.Context, usedHash string, now time.Time) (string, error) {
+	var id string
+	err := conn(ctx, r.db).QueryRow(ctx, `
+		UPDATE sessions SET revokedat = $2
+		WHERE id = (SELECT sessionid FROM session_used_tokens WHERE refreshhash = $1) AND revokedat IS NULL
+		RETURNING id`,
+		usedHash, now).Scan(&id)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", repository.ErrNotFound
+	}
+	return id, err
+}
+
+func (r *PgSessionsRepo) DeleteInactive(ctx context.Context, now time.Time) (int64, error) {
+	tag, err := conn(ctx, r.db).Exec(ctx, "DELETE FROM sessions WHERE expiresat <= $1 OR revokedat IS NOT NULL", now)
+	return tag.RowsAffected(), err
+}
@@ -5,14 +5,14 @@ import (
 	"errors"
 
 	"github.com/JustScorpio/loyalty_system/internal/customcontext"
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type PgxTransactionManager struct {
-	db *pgx.Conn
+	db *pgxpool.Pool
 }
 
-func NewPgxTransactionManager(db *pgx.Conn) *PgxTransactionManager {
+func NewPgxTransactionManager(db *pgxpool.Pool) *PgxTransactionManager {
 	return &PgxTransactionManager{db: db}
 }
 
@@ -37,7 +37,17 @@ func (tm *PgxTransactionManager) RunInTransaction(ctx context.Context, fn func(c
 	return tm.Commit(ctx)
 }
 
+// Begin начинает транзакцию. Если в контексте уже есть транзакция, начинает вложенную (SAVEPOINT):
+// её откат отменяет только изменения, сделанные внутри неё
 func (tm *PgxTransactionManager) Begin(ctx context.Context) (context.Context, error) {
+	if outer, ok := customcontext.GetTx(ctx); ok {
+		tx, err := outer.Begin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return customcontext.WithTx(ctx, tx), nil
+	}
+
 	tx, err := tm.db.Begin(ctx)
 	if err != nil {
 		return nil, err
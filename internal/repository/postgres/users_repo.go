@@ -2,36 +2,26 @@ package postgres
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"time"
 
 	"github.com/JustScorpio/loyalty_system/internal/customcontext"
 	"github.com/JustScorpio/loyalty_system/internal/models"
+	"github.com/JustScorpio/loyalty_system/internal/repository"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type PgUsersRepo struct {
-	db *pgx.Conn
+	db *pgxpool.Pool
 }
 
-func NewPgUsersRepo(db *pgx.Conn) (*PgUsersRepo, error) {
-	// Создание таблицы users, если её нет
-	_, err := db.Exec(context.Background(), `
-		CREATE TABLE IF NOT EXISTS users (
-			login TEXT NOT NULL PRIMARY KEY,
-			password TEXT,
-			currentpoints REAL,
-			withdrawnpoints REAL
-		)
-	`)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create tables: %w", err)
-	}
-
-	return &PgUsersRepo{db: db}, nil
+func NewPgUsersRepo(db *pgxpool.Pool) *PgUsersRepo {
+	return &PgUsersRepo{db: db}
 }
 
 func (r *PgUsersRepo) GetAll(ctx context.Context) ([]models.User, error) {
-	rows, err := r.db.Query(ctx, "SELECT login, password, currentpoints, withdrawnpoints FROM users")
+	rows, err := conn(ctx, r.db).Query(ctx, "SELECT login, password, currentpoints, withdrawnpoints, expiredpoints, tier FROM users")
 	if err != nil {
 		return nil, err
 	}
@@ -45,7 +35,7 @@ func (r *PgUsersRepo) GetAll(ctx context.Context) ([]models.User, error) {
 	var users []models.User
 	for rows.Next() {
 		var user models.User
-		err := rows.Scan(&user.Login, &user.Password, &user.CurrentPoints, &user.WithdrawnPoints)
+		err := rows.Scan(&user.Login, &user.Password, &user.CurrentPoints, &user.WithdrawnPoints, &user.ExpiredPoints, &user.Tier)
 		if err != nil {
 			return nil, err
 		}
@@ -57,8 +47,28 @@ func (r *PgUsersRepo) GetAll(ctx context.Context) ([]models.User, error) {
 
 func (r *PgUsersRepo) Get(ctx context.Context, login string) (*models.User, error) {
 	var user models.User
-	err := r.db.QueryRow(ctx, "SELECT login, password, currentpoints, withdrawnpoints FROM users WHERE login = $1", login).Scan(&user.Login, &user.Password, &user.CurrentPoints, &user.WithdrawnPoints)
+	err := conn(ctx, r.db).QueryRow(ctx, "SELECT login, password, currentpoints, withdrawnpoints, expiredpoints, tier FROM users WHERE login = $1", login).Scan(&user.Login, &user.Password, &user.CurrentPoints, &user.WithdrawnPoints, &user.ExpiredPoints, &user.Tier)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *PgUsersRepo) GetForUpdate(ctx context.Context, login string) (*models.User, error) {
+	if _, ok := customcontext.GetTx(ctx); !ok {
+		return nil, errors.New("user row can only be locked inside a transaction")
+	}
+
+	var user models.User
+	err := conn(ctx, r.db).QueryRow(ctx, "SELECT login, password, currentpoints, withdrawnpoints, expiredpoints, tier FROM users WHERE login = $1 FOR UPDATE", login).Scan(&user.Login, &user.Password, &user.CurrentPoints, &user.WithdrawnPoints, &user.ExpiredPoints, &user.Tier)
 
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, repository.ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -67,6 +77,9 @@ func (r *PgUsersRepo) Get(ctx context.Context, login string) (*models.User, erro
 
 func (r *PgUsersRepo) Create(ctx context.Context, user *models.User) error {
 	err := r.execQuery(ctx, "INSERT INTO users (login, password, currentpoints, withdrawnpoints) VALUES ($1, $2, $3, $4)", &user.Login, &user.Password, &user.CurrentPoints, &user.WithdrawnPoints)
+	if isUniqueViolation(err) {
+		return repository.ErrAlreadyExists
+	}
 	if err != nil {
 		return err
 	}
@@ -78,6 +91,69 @@ func (r *PgUsersRepo) Update(ctx context.Context, user *models.User) error {
 	return err
 }
 
+func (r *PgUsersRepo) UpdatePassword(ctx context.Context, login string, oldHash string, newHash string) (bool, error) {
+	tag, err := conn(ctx, r.db).Exec(ctx, "UPDATE users SET password = $3 WHERE login = $1 AND password = $2", login, oldHash, newHash)
+	return tag.RowsAffected() == 1, err
+}
+
+func (r *PgUsersRepo) UpdateTiers(ctx context.Context, tiers models.Tiers, since time.Time, now time.Time, after string, limit int) ([]models.TierChange, string, error) {
+	// Границы порции: пересчёт идёт диапазонами логинов, чтобы одна команда не блокировала строки всех пользователей
+	var last *string
+	err := conn(ctx, r.db).QueryRow(ctx, "SELECT MAX(login) FROM (SELECT login FROM users WHERE login > $1 ORDER BY login LIMIT $2) batch", after, limit).Scan(&last)
+	if err != nil {
+		return nil, "", err
+	}
+	if last == nil {
+		return nil, "", nil
+	}
+
+	names := make([]string, 0, len(tiers))
+	thresholds := make([]int64, 0, len(tiers))
+	for _, tier := range tiers {
+		names = append(names, tier.Name)
+		thresholds = append(thresholds, int64(tier.Threshold))
+	}
+
+	// Пороги передаются в сотых долях балла. Условия на вид проводки и счёт записаны литералами,
+	// чтобы планировщик использовал частичный индекс ledger_accruals_idx
+	rows, err := conn(ctx, r.db).Query(ctx, `
+		WITH tiers AS (
+			SELECT * FROM unnest($1::text[], $2::bigint[]) AS t(name, threshold)
+		), accrued AS (
+			SELECT u.login, u.tier AS oldtier, COALESCE((
+				SELECT SUM(l.amount) FROM ledger l
+				WHERE l.userid = u.login AND l.kind = 'ACCRUAL' AND l.account = 'CURRENT' AND l.createdat >= $3
+			), 0) AS points
+			FROM users u
+			WHERE u.login > $5 AND u.login <= $6
+		), computed AS (
+			SELECT a.login, a.oldtier, (
+				SELECT t.name FROM tiers t WHERE t.threshold <= a.points * 100 ORDER BY t.threshold DESC LIMIT 1
+			) AS tier
+			FROM accrued a
+		)
+		UPDATE users u SET tier = c.tier, tierupdatedat = $4
+		FROM computed c
+		WHERE u.login = c.login AND u.tier <> c.tier
+		RETURNING u.login, c.oldtier, u.tier`, names, thresholds, since, now, after, *last)
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer rows.Close()
+
+	var changes []models.TierChange
+	for rows.Next() {
+		var change models.TierChange
+		if err := rows.Scan(&change.UserID, &change.From, &change.To); err != nil {
+			return nil, "", err
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, *last, rows.Err()
+}
+
 func (r *PgUsersRepo) Delete(ctx context.Context, login string) error {
 	err := r.execQuery(ctx, "DELETE FROM users WHERE login = $1", login)
 	return err
@@ -90,10 +166,6 @@ func (r *PgUsersRepo) PingDB() bool {
 
 // execQuery выполняет запрос, автоматически используя транзакцию из контекста если она есть
 func (r *PgUsersRepo) execQuery(ctx context.Context, query string, args ...interface{}) error {
-	if tx, ok := customcontext.GetTx(ctx); ok {
-		_, err := tx.Exec(ctx, query, args...)
-		return err
-	}
-	_, err := r.db.Exec(ctx, query, args...)
+	_, err := conn(ctx, r.db).Exec(ctx, query, args...)
 	return err
 }
@@ -2,36 +2,32 @@ package postgres
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"time"
 
-	"github.com/JustScorpio/loyalty_system/internal/customcontext"
 	"github.com/JustScorpio/loyalty_system/internal/models"
+	"github.com/JustScorpio/loyalty_system/internal/repository"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-type PgWithdrawalsRepo struct {
-	db *pgx.Conn
+// Колонки списания в порядке полей withdrawalFields
+const withdrawalColumns = `userid, "order", sum, processedat, status, reversedat, COALESCE(reversalreason, '')`
+
+func withdrawalFields(withdrawal *models.Withdrawal) []interface{} {
+	return []interface{}{&withdrawal.UserID, &withdrawal.Order, &withdrawal.Sum, &withdrawal.ProcessedAt, &withdrawal.Status, &withdrawal.ReversedAt, &withdrawal.ReversalReason}
 }
 
-func NewPgWithdrawalsRepo(db *pgx.Conn) (*PgWithdrawalsRepo, error) {
-	// Создание таблицы withdrawals, если её нет
-	_, err := db.Exec(context.Background(), `
-		CREATE TABLE IF NOT EXISTS withdrawals (
-			userid TEXT NOT NULL,
-			"order" TEXT NOT NULL PRIMARY KEY,
-			sum REAL,
-			processedat TIMESTAMP
-		);
-	`)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create tables: %w", err)
-	}
+type PgWithdrawalsRepo struct {
+	db *pgxpool.Pool
+}
 
-	return &PgWithdrawalsRepo{db: db}, nil
+func NewPgWithdrawalsRepo(db *pgxpool.Pool) *PgWithdrawalsRepo {
+	return &PgWithdrawalsRepo{db: db}
 }
 
 func (r *PgWithdrawalsRepo) GetAll(ctx context.Context) ([]models.Withdrawal, error) {
-	rows, err := r.db.Query(ctx, "SELECT userid, \"order\", sum, processedat FROM withdrawals")
+	rows, err := conn(ctx, r.db).Query(ctx, "SELECT "+withdrawalColumns+" FROM withdrawals")
 	if err != nil {
 		return nil, err
 	}
@@ -45,7 +41,7 @@ func (r *PgWithdrawalsRepo) GetAll(ctx context.Context) ([]models.Withdrawal, er
 	var withdrawals []models.Withdrawal
 	for rows.Next() {
 		var withdrawal models.Withdrawal
-		err := rows.Scan(&withdrawal.UserID, &withdrawal.Order, &withdrawal.Sum, &withdrawal.ProcessedAt)
+		err := rows.Scan(withdrawalFields(&withdrawal)...)
 		if err != nil {
 			return nil, err
 		}
@@ -57,7 +53,7 @@ func (r *PgWithdrawalsRepo) GetAll(ctx context.Context) ([]models.Withdrawal, er
 
 func (r *PgWithdrawalsRepo) Get(ctx context.Context, order string) (*models.Withdrawal, error) {
 	var withdrawal models.Withdrawal
-	err := r.db.QueryRow(ctx, "SELECT userid, \"order\", sum, processedat FROM withdrawals WHERE \"order\" = $1", order).Scan(&withdrawal.UserID, &withdrawal.Order, &withdrawal.Sum, &withdrawal.ProcessedAt)
+	err := conn(ctx, r.db).QueryRow(ctx, "SELECT "+withdrawalColumns+" FROM withdrawals WHERE \"order\" = $1", order).Scan(withdrawalFields(&withdrawal)...)
 
 	if err != nil {
 		return nil, err
@@ -65,14 +61,74 @@ func (r *PgWithdrawalsRepo) Get(ctx context.Context, order string) (*models.With
 	return &withdrawal, nil
 }
 
+func (r *PgWithdrawalsRepo) GetByUser(ctx context.Context, userID string, filter models.WithdrawalFilter) ([]models.Withdrawal, error) {
+	// Запрос обслуживается индексом withdrawals_userid_idx
+	var b queryBuilder
+	b.where("userid = " + b.arg(userID))
+	if filter.Order != "" {
+		b.where("\"order\" = " + b.arg(filter.Order))
+	}
+	b.timeRange("processedat", filter.Processed)
+	b.keyset("processedat", "\"order\"", filter.Cursor)
+
+	query := b.build("SELECT "+withdrawalColumns+" FROM withdrawals", "processedat DESC, \"order\" DESC", filter.Limit)
+	rows, err := conn(ctx, r.db).Query(ctx, query, b.args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var withdrawals []models.Withdrawal
+	for rows.Next() {
+		var withdrawal models.Withdrawal
+		err := rows.Scan(withdrawalFields(&withdrawal)...)
+		if err != nil {
+			return nil, err
+		}
+		withdrawals = append(withdrawals, withdrawal)
+	}
+
+	return withdrawals, rows.Err()
+}
+
 func (r *PgWithdrawalsRepo) Create(ctx context.Context, withdrawal *models.Withdrawal) error {
-	err := r.execQuery(ctx, "INSERT INTO withdrawals (userid, \"order\", sum, processedat) VALUES ($1, $2, $3, $4)", withdrawal.UserID, withdrawal.Order, withdrawal.Sum, withdrawal.ProcessedAt)
+	err := r.execQuery(ctx, "INSERT INTO withdrawals (userid, \"order\", sum, processedat, status) VALUES ($1, $2, $3, $4, $5)", withdrawal.UserID, withdrawal.Order, withdrawal.Sum, withdrawal.ProcessedAt, withdrawal.Status)
+	if isUniqueViolation(err) {
+		return repository.ErrAlreadyExists
+	}
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+func (r *PgWithdrawalsRepo) MarkReversed(ctx context.Context, order string, reason string, at time.Time) (*models.Withdrawal, error) {
+	// Условие на статус: из параллельных отмен одного списания выполняется только одна
+	var withdrawal models.Withdrawal
+	err := conn(ctx, r.db).QueryRow(ctx, `
+		UPDATE withdrawals SET status = $2, reversedat = $3, reversalreason = $4
+		WHERE "order" = $1 AND status = $5
+		RETURNING `+withdrawalColumns,
+		order, models.WithdrawalReversed, at, reason, models.WithdrawalProcessed).Scan(withdrawalFields(&withdrawal)...)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Списания нет или оно уже отменено
+		var exists bool
+		if err := conn(ctx, r.db).QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM withdrawals WHERE \"order\" = $1)", order).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, repository.ErrAlreadyReversed
+		}
+		return nil, repository.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &withdrawal, nil
+}
+
 func (r *PgWithdrawalsRepo) Update(ctx context.Context, withdrawal *models.Withdrawal) error {
 	err := r.execQuery(ctx, "UPDATE withdrawals SET userid = $1, sum = $3, processedat = $4 WHERE \"order\" = $2", withdrawal.UserID, withdrawal.Order, withdrawal.Sum, withdrawal.ProcessedAt)
 	return err
@@ -90,10 +146,6 @@ func (r *PgWithdrawalsRepo) PingDB() bool {
 
 // execQuery выполняет запрос, автоматически используя транзакцию из контекста если она есть
 func (r *PgWithdrawalsRepo) execQuery(ctx context.Context, query string, args ...interface{}) error {
-	if tx, ok := customcontext.GetTx(ctx); ok {
-		_, err := tx.Exec(ctx, query, args...)
-		return err
-	}
-	_, err := r.db.Exec(ctx, query, args...)
+	_, err := conn(ctx, r.db).Exec(ctx, query, args...)
 	return err
 }
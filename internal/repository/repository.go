@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"github.com/JustScorpio/loyalty_system/internal/models"
 )
@@ -16,3 +17,122 @@ type IRepository[T models.Entity] interface {
 
 	PingDB() bool
 }
+
+// Репозиторий пользователей
+type IUsersRepository interface {
+	IRepository[models.User]
+
+	// GetForUpdate возвращает пользователя и блокирует его строку до конца транзакции
+	GetForUpdate(ctx context.Context, login string) (*models.User, error)
+	// UpdatePassword заменяет хэш пароля, только если он всё ещё равен oldHash.
+	// Возвращает false, если пароль успели изменить
+	UpdatePassword(ctx context.Context, login string, oldHash string, newHash string) (bool, error)
+	// UpdateTiers пересчитывает уровни не более limit пользователей с логином больше after по баллам, начисленным
+	// с момента since. Возвращает пользователей, уровень которых изменился, и последний обработанный логин
+	// (пустой, если пользователей после after нет)
+	UpdateTiers(ctx context.Context, tiers models.Tiers, since time.Time, now time.Time, after string, limit int) ([]models.TierChange, string, error)
+}
+
+// Репозиторий заказов
+type IOrdersRepository interface {
+	IRepository[models.Order]
+
+	// ClaimPending захватывает для экземпляра owner до limit заказов в статусах NEW и PROCESSING, время опроса которых
+	// наступило к моменту now. Захват действует до leaseUntil, заказы, захваченные другими экземплярами, пропускаются
+	ClaimPending(ctx context.Context, owner string, now time.Time, leaseUntil time.Time, limit int) ([]models.Order, error)
+	// UpdatePending обновляет заказ и снимает с него захват, только если заказ ещё не в финальном статусе
+	// и всё ещё захвачен экземпляром owner. Возвращает false, если заказ уже обработан или захват истёк
+	// и перешёл к другому экземпляру
+	UpdatePending(ctx context.Context, owner string, order *models.Order) (bool, error)
+	// GetByUser возвращает заказы пользователя, подходящие под фильтр, от новых к старым
+	GetByUser(ctx context.Context, userID string, filter models.OrderFilter) ([]models.Order, error)
+	// HasProcessed проверяет, есть ли у пользователя обработанные заказы, кроме заказа except
+	HasProcessed(ctx context.Context, userID string, except string) (bool, error)
+}
+
+// Репозиторий списаний
+type IWithdrawalsRepository interface {
+	IRepository[models.Withdrawal]
+
+	// GetByUser возвращает списания пользователя, подходящие под фильтр, от новых к старым
+	GetByUser(ctx context.Context, userID string, filter models.WithdrawalFilter) ([]models.Withdrawal, error)
+	// MarkReversed переводит списание в статус REVERSED. Возвращает ErrNotFound, если списания нет,
+	// и ErrAlreadyReversed, если оно уже отменено
+	MarkReversed(ctx context.Context, order string, reason string, at time.Time) (*models.Withdrawal, error)
+}
+
+// Журнал баллов (только добавление записей)
+type ILedgerRepository interface {
+	// Post добавляет проводки одной или нескольких операций и в той же транзакции обновляет сохранённые балансы пользователей.
+	// Если текущий баланс какого-либо пользователя стал бы отрицательным, возвращает ErrInsufficientFunds
+	Post(ctx context.Context, entries []models.LedgerEntry) error
+	// GetBalance рассчитывает баланс пользователя по журналу: сохранённый баланс служит кэшем проводок до отметки
+	// в нём, более поздние проводки досчитываются. Возвращает и количество досчитанных проводок (0 - снимок актуален)
+	GetBalance(ctx context.Context, userID string) (*models.Balance, int64, error)
+	// GetAccruedSince сумма начислений по заказам пользователя с момента since (без бонусов уровня)
+	GetAccruedSince(ctx context.Context, userID string, since time.Time) (models.Points, error)
+	// FindDiscrepancies возвращает пользователей, сохранённый баланс которых расходится с журналом
+	FindDiscrepancies(ctx context.Context) ([]models.BalanceDiscrepancy, error)
+}
+
+// Репозиторий сессий пользователей
+type ISessionsRepository interface {
+	Create(ctx context.Context, session *models.Session) error
+	// Rotate заменяет refresh-токен сессии и продлевает её до expiresAt. Возвращает ErrNotFound, если действующей
+	// на момент now сессии с токеном oldHash нет (токен уже обновлён, сессия отозвана или истекла)
+	Rotate(ctx context.Context, oldHash string, newHash string, now time.Time, expiresAt time.Time) (*models.Session, error)
+	// IsActive проверяет, что сессия не отозвана и не истекла на момент now
+	IsActive(ctx context.Context, id string, now time.Time) (bool, error)
+	// Revoke отзывает сессию пользователя
+	Revoke(ctx context.Context, userID string, id string, now time.Time) error
+	// RevokeAll отзывает все сессии пользователя
+	RevokeAll(ctx context.Context, userID string, now time.Time) error
+	// RevokeByUsedToken отзывает сессию, refresh-токен которой с хэшем usedHash уже был обновлён, и возвращает её id.
+	// ErrNotFound - такого токена не выдавалось или сессия уже отозвана
+	RevokeByUsedToken(ctx context.Context, usedHash string, now time.Time) (string, error)
+	// DeleteInactive удаляет сессии, истёкшие к моменту now или отозванные
+	DeleteInactive(ctx context.Context, now time.Time) (int64, error)
+}
+
+// Репозиторий ключей идемпотентности
+type IIdempotencyRepository interface {
+	// Acquire регистрирует ключ. Если действующий ключ уже есть, возвращает его и ничего не меняет (истёкший ключ
+	// регистрируется заново). Вызывается в транзакции: запись ключа заблокирована до её завершения
+	Acquire(ctx context.Context, key *models.IdempotencyKey) (*models.IdempotencyKey, error)
+	// Complete сохраняет статус ответа на запрос
+	Complete(ctx context.Context, userID string, key string, statusCode int) error
+	// DeleteExpired удаляет ключи, истёкшие к моменту now
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+// Репозиторий партий начисленных баллов
+type IPointLotsRepository interface {
+	Add(ctx context.Context, lot *models.PointLot) error
+	// Consume расходует amount баллов пользователя из партий по порядку начисления (сначала сгорающие).
+	// Вызывается в транзакции вместе с проводкой списания
+	Consume(ctx context.Context, userID string, amount models.Points) error
+	// FindUsersWithExpired возвращает до limit пользователей (по возрастанию логина, после after), у которых есть
+	// сгорающие партии с остатком, начисленные раньше createdBefore
+	FindUsersWithExpired(ctx context.Context, createdBefore time.Time, after string, limit int) ([]string, error)
+	// ClaimExpired блокирует сгорающие партии пользователя с остатком, начисленные раньше createdBefore.
+	// Партии, заблокированные другими транзакциями, пропускаются
+	ClaimExpired(ctx context.Context, userID string, createdBefore time.Time) ([]models.PointLot, error)
+	// MarkExpired обнуляет остаток партии, записывая amount как сгоревшие баллы
+	MarkExpired(ctx context.Context, id int64, amount models.Points, at time.Time) error
+	// GetExpiring возвращает сгорающие партии пользователя с остатком, начисленные раньше createdBefore
+	GetExpiring(ctx context.Context, userID string, createdBefore time.Time) ([]models.PointLot, error)
+}
+
+// Репозиторий промо-акций и начисленных по ним бонусов
+type ICampaignsRepository interface {
+	GetAll(ctx context.Context) ([]models.Campaign, error)
+	// Get возвращает ErrNotFound, если акции нет
+	Get(ctx context.Context, id int64) (*models.Campaign, error)
+	Create(ctx context.Context, campaign *models.Campaign) error
+	// Update возвращает ErrNotFound, если акции нет
+	Update(ctx context.Context, campaign *models.Campaign) error
+	// GetActive возвращает включённые акции, действующие в момент at
+	GetActive(ctx context.Context, at time.Time) ([]models.Campaign, error)
+	// AddBonus записывает бонус по акции. Повторный бонус за тот же заказ - ErrAlreadyExists
+	AddBonus(ctx context.Context, bonus *models.CampaignBonus) error
+}
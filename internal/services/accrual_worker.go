@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/JustScorpio/loyalty_system/internal/accrual"
+	"github.com/JustScorpio/loyalty_system/internal/models"
+)
+
+const (
+	accrualPollInterval = time.Second     // Как часто проверять БД на наличие заказов к опросу
+	pendingPollInterval = 3 * time.Second // Как часто переспрашивать о заказе, который система начислений ещё обрабатывает
+	baseRetryDelay      = 3 * time.Second // Задержка перед первой повторной попыткой
+	maxRetryDelay       = 5 * time.Minute // Максимальная задержка между попытками
+)
+
+// startAccrualWorkers запускает планировщик и пул воркеров опроса системы начислений
+func (s *LoyaltyService) startAccrualWorkers(ctx context.Context) {
+	workers := max(s.config.AccrualWorkers, 1)
+	jobs := make(chan models.Order)
+
+	for i := 0; i < workers; i++ {
+		s.workersWG.Add(1)
+		go func() {
+			defer s.workersWG.Done()
+			s.ordersAccrualWorker(ctx, jobs)
+		}()
+	}
+
+	s.workersWG.Add(1)
+	go func() {
+		defer s.workersWG.Done()
+		defer close(jobs)
+		s.ordersAccrualScheduler(ctx, jobs)
+	}()
+}
+
+// ordersAccrualScheduler берёт из БД заказы в статусах NEW и PROCESSING, у которых подошло время очередной попытки,
+// и раздаёт их воркерам. Очередь хранится в таблице orders, поэтому переживает перезапуски сервиса
+func (s *LoyaltyService) ordersAccrualScheduler(ctx context.Context, jobs chan<- models.Order) {
+	ticker := time.NewTicker(accrualPollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.dispatchPendingOrders(ctx, jobs)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.accrualWakeup:
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatchPendingOrders захватывает заказы, время опроса которых уже наступило, и отдаёт их воркерам.
+// Захват (аренда) в БД не даёт другим экземплярам сервиса опрашивать те же заказы
+func (s *LoyaltyService) dispatchPendingOrders(ctx context.Context, jobs chan<- models.Order) {
+	batch := max(s.config.AccrualWorkers, 1)
+
+	for {
+		now := time.Now()
+
+		// Система начислений попросила паузу (429) - заказы не захватываем, иначе воркеры только ждали бы её окончания
+		if now.Before(s.accrualClient.PausedUntil()) {
+			return
+		}
+
+		dbCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		orders, err := s.ordersRepo.ClaimPending(dbCtx, s.config.InstanceID, now, now.Add(s.config.AccrualLeaseTimeout), batch)
+		cancel()
+
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Failed to claim pending orders: %v", err)
+			}
+			return
+		}
+
+		for _, order := range orders {
+			// Заказ ещё обрабатывается одним из воркеров (аренда истекла раньше, чем закончилась обработка)
+			if _, busy := s.inFlightOrders.LoadOrStore(order.Number, struct{}{}); busy {
+				continue
+			}
+
+			select {
+			case jobs <- order:
+			case <-ctx.Done():
+				s.inFlightOrders.Delete(order.Number)
+				return
+			}
+		}
+
+		// Пачка неполная - больше заказов к опросу нет
+		if len(orders) < batch {
+			return
+		}
+	}
+}
+
+// ordersAccrualWorker опрашивает систему начислений по заказам из очереди jobs
+func (s *LoyaltyService) ordersAccrualWorker(ctx context.Context, jobs <-chan models.Order) {
+	for order := range jobs {
+		s.checkOrderStatus(ctx, &order)
+		s.inFlightOrders.Delete(order.Number)
+	}
+}
+
+// retryDelay экспоненциальная задержка перед следующей попыткой
+func retryDelay(attempts int) time.Duration {
+	delay := baseRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// scheduleRetry сохраняет в БД время следующей попытки опроса заказа и снимает с него захват
+func (s *LoyaltyService) scheduleRetry(ctx context.Context, order *models.Order) {
+	order.Attempts++
+	s.scheduleAt(ctx, order, time.Now().Add(retryDelay(order.Attempts)))
+}
+
+// scheduleAt откладывает опрос заказа до момента at
+func (s *LoyaltyService) scheduleAt(ctx context.Context, order *models.Order, at time.Time) {
+	order.NextAttemptAt = at
+
+	updated, err := s.ordersRepo.UpdatePending(ctx, s.config.InstanceID, order)
+	if err != nil {
+		log.Printf("Failed to schedule retry for order %s: %v", order.Number, err)
+		return
+	}
+	if !updated {
+		log.Printf("Lease on order %s was lost, leaving it to its new owner", order.Number)
+	}
+}
+
+// handleNotRegisteredOrder оставляет заказ в NEW, пока не истечёт срок ожидания регистрации, после чего переводит его в INVALID
+func (s *LoyaltyService) handleNotRegisteredOrder(ctx context.Context, order *models.Order) {
+	if time.Since(order.UploadedAt) < s.config.NotRegisteredTimeout {
+		s.scheduleRetry(ctx, order)
+		return
+	}
+
+	order.Status = models.StatusInvalid
+	updated, err := s.ordersRepo.UpdatePending(ctx, s.config.InstanceID, order)
+	if err != nil {
+		log.Printf("Failed to invalidate order %s: %v", order.Number, err)
+		return
+	}
+	if !updated {
+		return // Заказ уже обработан или захвачен другим экземпляром
+	}
+
+	log.Printf("Order %s was not registered in accrual system within %v, marked as %s", order.Number, s.config.NotRegisteredTimeout, models.StatusInvalid)
+}
+
+// checkOrderStatus опрашивает систему начислений по одному заказу. stopCtx отменяется при остановке сервиса:
+// он прерывает ожидание ответа, но не начатую транзакцию записи результата
+func (s *LoyaltyService) checkOrderStatus(stopCtx context.Context, order *models.Order) {
+	requestCtx, cancelRequest := context.WithTimeout(stopCtx, 10*time.Second)
+	defer cancelRequest()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Запрашиваем обновление статуса
+	orderInfo, err := s.accrualClient.GetOrderInfo(requestCtx, order.Number)
+	if err != nil {
+		// Сервис останавливается - заказ будет опрошен после перезапуска
+		if stopCtx.Err() != nil {
+			return
+		}
+
+		var rateLimitErr *accrual.RateLimitError
+		if errors.As(err, &rateLimitErr) {
+			// Клиент уже приостановил запросы всех воркеров. Попытку не засчитываем - заказ будет опрошен сразу после паузы
+			log.Printf("Accrual system rate limit exceeded (limit %d rpm), pausing for %v", rateLimitErr.Limit, rateLimitErr.RetryAfter)
+			s.scheduleAt(ctx, order, time.Now().Add(rateLimitErr.RetryAfter))
+			return
+		}
+
+		var waitErr *accrual.WaitError
+		if errors.As(err, &waitErr) {
+			// Запрос не дождался очереди в общем бюджете запросов. С заказом всё в порядке - попытку не засчитываем
+			s.scheduleAt(ctx, order, waitErr.AllowedAt)
+			return
+		}
+
+		if errors.Is(err, accrual.ErrOrderNotRegistered) {
+			s.handleNotRegisteredOrder(ctx, order)
+			return
+		}
+
+		log.Printf("Failed to check order %s: %v", order.Number, err)
+		s.scheduleRetry(ctx, order)
+		return
+	}
+
+	// Переводим статус системы начислений в статус заказа. Неизвестные статусы в БД не пишем
+	status, err := orderInfo.Status.ToOrderStatus()
+	if err != nil {
+		log.Printf("Rejected accrual response for order %s: %v", order.Number, err)
+		s.scheduleRetry(ctx, order)
+		return
+	}
+
+	// Если статус ещё не финальный - продолжаем проверять с обычной периодичностью. Это не ошибка:
+	// счётчик попыток сбрасывается, чтобы задержка не росла, пока заказ нормально обрабатывается
+	if status == models.StatusNew || status == models.StatusProcessing {
+		order.Status = status
+		order.Attempts = 0
+		s.scheduleAt(ctx, order, time.Now().Add(pendingPollInterval))
+		return
+	}
+
+	//Добавляем начисления и увеличиваем баланс паользователя в одной транзакции
+	updatedOrder := *order
+	updatedOrder.Status = status
+	updatedOrder.Accrual = orderInfo.Accrual
+
+	err = s.txManager.RunInTransaction(ctx, func(ctx context.Context) error {
+		// Обновляем заказ. Если другой экземпляр успел его обработать или перехватил истёкший захват - баланс не трогаем
+		updated, err := s.ordersRepo.UpdatePending(ctx, s.config.InstanceID, &updatedOrder)
+		if err != nil || !updated {
+			return err
+		}
+
+		// Обновляем баланс пользователя
+		if updatedOrder.Status != models.StatusProcessed {
+			return nil
+		}
+		if updatedOrder.Accrual > 0 {
+			if err := s.ledgerRepo.Post(ctx, models.NewAccrualEntries(order.UserID, order.Number, updatedOrder.Accrual)); err != nil {
+				return err
+			}
+		}
+
+		// Бонусы уровня пользователя и акций - отдельными операциями, сумма заказа остаётся такой, как её вернула система начислений
+		bonus, err := s.postBonuses(ctx, updatedOrder)
+		if err != nil {
+			return err
+		}
+
+		if updatedOrder.Accrual+bonus <= 0 {
+			return nil
+		}
+
+		// Срок сгорания начисленных баллов отсчитывается от момента перевода заказа в PROCESSED
+		return s.pointLotsRepo.Add(ctx, &models.PointLot{
+			UserID:    order.UserID,
+			Reference: order.Number,
+			Amount:    updatedOrder.Accrual + bonus,
+			CreatedAt: time.Now(),
+			Expirable: true,
+		})
+	})
+
+	if err != nil {
+		log.Printf("Failed to update order %s: %v", order.Number, err)
+		s.scheduleRetry(ctx, order)
+	}
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/JustScorpio/loyalty_system/internal/customerrors"
+	"github.com/JustScorpio/loyalty_system/internal/models"
+	"github.com/JustScorpio/loyalty_system/internal/repository"
+)
+
+func (s *LoyaltyService) GetCampaigns(ctx context.Context) ([]models.Campaign, error) {
+	return s.campaignsRepo.GetAll(ctx)
+}
+
+func (s *LoyaltyService) GetCampaign(ctx context.Context, id int64) (*models.Campaign, error) {
+	campaign, err := s.campaignsRepo.Get(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, customerrors.NewNotFoundError(err)
+	}
+	if err != nil {
+		return nil, customerrors.NewInternalServerError(err)
+	}
+	return campaign, nil
+}
+
+// CreateCampaign создаёт акцию. Акция применяется к заказам, загруженным в период её действия,
+// в том числе к уже загруженным, но ещё не обработанным
+func (s *LoyaltyService) CreateCampaign(ctx context.Context, campaign models.Campaign) (*models.Campaign, error) {
+	if err := s.prepareCampaign(&campaign); err != nil {
+		return nil, err
+	}
+	campaign.CreatedAt = time.Now()
+
+	if err := s.campaignsRepo.Create(ctx, &campaign); err != nil {
+		return nil, customerrors.NewInternalServerError(err)
+	}
+	return &campaign, nil
+}
+
+// UpdateCampaign изменяет акцию. Уже начисленные по ней бонусы не пересчитываются
+func (s *LoyaltyService) UpdateCampaign(ctx context.Context, campaign models.Campaign) (*models.Campaign, error) {
+	if err := s.prepareCampaign(&campaign); err != nil {
+		return nil, err
+	}
+
+	err := s.campaignsRepo.Update(ctx, &campaign)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, customerrors.NewNotFoundError(err)
+	}
+	if err != nil {
+		return nil, customerrors.NewInternalServerError(err)
+	}
+
+	return s.GetCampaign(ctx, campaign.ID)
+}
+
+// DeactivateCampaign отключает акцию. Акция не удаляется - на неё ссылаются начисленные бонусы
+func (s *LoyaltyService) DeactivateCampaign(ctx context.Context, id int64) (*models.Campaign, error) {
+	campaign, err := s.GetCampaign(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	campaign.Active = false
+	return s.UpdateCampaign(ctx, *campaign)
+}
+
+// prepareCampaign приводит уровни к виду, в котором они заданы в настройках, и проверяет акцию
+func (s *LoyaltyService) prepareCampaign(campaign *models.Campaign) error {
+	if len(campaign.Tiers) > 0 && len(s.config.Tiers) == 0 {
+		return customerrors.NewUnprocessableEntityError(errors.New("loyalty tiers are disabled"))
+	}
+	for i, tier := range campaign.Tiers {
+		campaign.Tiers[i] = strings.ToUpper(strings.TrimSpace(tier))
+		if len(s.config.Tiers) > 0 && s.config.Tiers.Lookup(campaign.Tiers[i]).Name != campaign.Tiers[i] {
+			return customerrors.NewUnprocessableEntityError(errors.New("unknown tier " + campaign.Tiers[i]))
+		}
+	}
+
+	if err := campaign.Validate(); err != nil {
+		return customerrors.NewUnprocessableEntityError(err)
+	}
+	return nil
+}
+
+// postBonuses начисляет бонусы уровня пользователя и акций к заказу, переведённому в PROCESSED,
+// и возвращает их сумму. Вызывается в транзакции начисления по заказу
+func (s *LoyaltyService) postBonuses(ctx context.Context, order models.Order) (models.Points, error) {
+	// Период акции сравнивается с моментом загрузки заказа: обработка может завершиться уже после окончания акции
+	campaigns, err := s.campaignsRepo.GetActive(ctx, order.UploadedAt)
+	if err != nil {
+		return 0, err
+	}
+	if len(campaigns) == 0 && len(s.config.Tiers) == 0 {
+		return 0, nil
+	}
+
+	// Строка пользователя блокируется до проверки первого заказа: иначе два заказа пользователя, обрабатываемые
+	// параллельно, не видят друг друга и оба получают бонус первого заказа (при нулевом начислении по заказу
+	// строку пользователя больше ничто не блокирует)
+	user, err := s.usersRepo.GetForUpdate(ctx, order.UserID)
+	if err != nil {
+		return 0, err
+	}
+
+	tierBonus, err := s.postTierBonus(ctx, user, order)
+	if err != nil {
+		return 0, err
+	}
+
+	campaignBonus, err := s.postCampaignBonuses(ctx, campaigns, user, order)
+	if err != nil {
+		return 0, err
+	}
+
+	return tierBonus + campaignBonus, nil
+}
+
+// postCampaignBonuses начисляет бонусы всех подходящих акций. Бонусы акций суммируются и считаются от начисления
+// по заказу без бонуса уровня. Каждый бонус - отдельная операция журнала и отдельная запись в истории бонусов
+func (s *LoyaltyService) postCampaignBonuses(ctx context.Context, campaigns []models.Campaign, user *models.User, order models.Order) (models.Points, error) {
+	if len(campaigns) == 0 {
+		return 0, nil
+	}
+
+	firstOrder := false
+	for _, campaign := range campaigns {
+		if campaign.FirstOrderOnly {
+			processed, err := s.ordersRepo.HasProcessed(ctx, order.UserID, order.Number)
+			if err != nil {
+				return 0, err
+			}
+			firstOrder = !processed
+			break
+		}
+	}
+
+	now := time.Now()
+	tier := s.userTier(user)
+	var total models.Points
+	for _, campaign := range campaigns {
+		if !campaign.Eligible(order, tier, firstOrder) {
+			continue
+		}
+
+		bonus := campaign.Bonus(order.Accrual)
+		if bonus <= 0 {
+			continue
+		}
+
+		err := s.campaignsRepo.AddBonus(ctx, &models.CampaignBonus{
+			CampaignID: campaign.ID,
+			Order:      order.Number,
+			UserID:     order.UserID,
+			Amount:     bonus,
+			CreatedAt:  now,
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		if err := s.ledgerRepo.Post(ctx, models.NewCampaignBonusEntries(order.UserID, campaign.ID, order.Number, bonus)); err != nil {
+			return 0, err
+		}
+		total += bonus
+	}
+
+	return total, nil
+}
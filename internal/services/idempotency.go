@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/JustScorpio/loyalty_system/internal/customerrors"
+	"github.com/JustScorpio/loyalty_system/internal/models"
+)
+
+var idempotencyKeyReusedError = customerrors.NewUnprocessableEntityError(errors.New("idempotency key was already used with a different request"))
+var idempotencyKeyInProgressError = customerrors.NewAlreadyExistsError(errors.New("request with this idempotency key is still in progress"))
+
+// idempotentWithdrawal запрос на списание с ключом идемпотентности
+type idempotentWithdrawal struct {
+	withdrawal models.Withdrawal
+	key        string
+}
+
+// requestHash отпечаток содержимого запроса на списание
+func (r idempotentWithdrawal) requestHash() string {
+	sum := sha256.Sum256([]byte(r.withdrawal.Order + "|" + r.withdrawal.Sum.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// createWithdrawalIdempotent выполняет списание и сохраняет статус ответа в одной транзакции с ним.
+// Ошибки сервера не сохраняются: транзакция откатывается вместе с ключом, и запрос можно повторить
+func (s *LoyaltyService) createWithdrawalIdempotent(ctx context.Context, req idempotentWithdrawal) error {
+	now := time.Now()
+	key := models.IdempotencyKey{
+		UserID:      req.withdrawal.UserID,
+		Key:         req.key,
+		RequestHash: req.requestHash(),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.config.IdempotencyKeyTTL),
+	}
+
+	var result error
+	err := s.txManager.RunInTransaction(ctx, func(ctx context.Context) error {
+		existing, err := s.idempotencyRepo.Acquire(ctx, &key)
+		if err != nil {
+			return err
+		}
+
+		// Повтор запроса - возвращаем сохранённый результат
+		if existing != nil {
+			switch {
+			case existing.RequestHash != key.RequestHash:
+				result = idempotencyKeyReusedError
+			case existing.StatusCode == 0:
+				result = idempotencyKeyInProgressError
+			default:
+				result = customerrors.NewHTTPError(errors.New("replayed response"), existing.StatusCode)
+			}
+			return nil
+		}
+
+		// Списание выполняется во вложенной транзакции: отказ (402, 422) откатывает только его, а статус сохраняется
+		result = s.createWithdrawal(ctx, req.withdrawal)
+
+		statusCode := http.StatusOK
+		var httpErr *customerrors.HTTPError
+		if errors.As(result, &httpErr) {
+			statusCode = httpErr.Code
+		}
+		if statusCode >= http.StatusInternalServerError {
+			return result
+		}
+
+		return s.idempotencyRepo.Complete(ctx, key.UserID, key.Key, statusCode)
+	})
+
+	if err != nil {
+		var httpErr *customerrors.HTTPError
+		if errors.As(err, &httpErr) {
+			return err
+		}
+		return customerrors.NewInternalServerError(fmt.Errorf("idempotent withdrawal failed: %w", err))
+	}
+
+	return result
+}
+
+// startIdempotencyCleaner периодически удаляет истёкшие ключи идемпотентности
+func (s *LoyaltyService) startIdempotencyCleaner(ctx context.Context) {
+	if s.config.IdempotencyKeyTTL <= 0 {
+		return
+	}
+
+	s.workersWG.Add(1)
+	go func() {
+		defer s.workersWG.Done()
+
+		ticker := time.NewTicker(min(s.config.IdempotencyKeyTTL, time.Hour))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if _, err := s.idempotencyRepo.DeleteExpired(ctx, time.Now()); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to delete expired idempotency keys: %v", err)
+			}
+		}
+	}()
+}
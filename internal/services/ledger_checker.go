@@ -0,0 +1,51 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/JustScorpio/loyalty_system/internal/models"
+)
+
+// startLedgerChecker периодически сверяет сохранённые балансы пользователей с журналом баллов
+func (s *LoyaltyService) startLedgerChecker(ctx context.Context) {
+	if s.config.LedgerCheckInterval <= 0 {
+		return
+	}
+
+	s.workersWG.Add(1)
+	go func() {
+		defer s.workersWG.Done()
+
+		ticker := time.NewTicker(s.config.LedgerCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := s.CheckLedger(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Ledger consistency check failed: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// CheckLedger возвращает пользователей, сохранённый баланс которых расходится с журналом, и пишет их в лог
+func (s *LoyaltyService) CheckLedger(ctx context.Context) ([]models.BalanceDiscrepancy, error) {
+	discrepancies, err := s.ledgerRepo.FindDiscrepancies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range discrepancies {
+		log.Printf("Balance of user %s does not match ledger: snapshot current=%v withdrawn=%v expired=%v, ledger current=%v withdrawn=%v expired=%v",
+			d.UserID, d.Snapshot.Current, d.Snapshot.Withdrawn, d.Snapshot.Expired, d.Ledger.Current, d.Ledger.Withdrawn, d.Ledger.Expired)
+	}
+
+	return discrepancies, nil
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/JustScorpio/loyalty_system/internal/accrual"
@@ -12,139 +14,204 @@ import (
 	dispatcher "github.com/JustScorpio/loyalty_system/internal/infrastructure"
 	"github.com/JustScorpio/loyalty_system/internal/models"
 	"github.com/JustScorpio/loyalty_system/internal/repository"
+	"github.com/JustScorpio/loyalty_system/internal/utils/auth/password"
 	"github.com/JustScorpio/loyalty_system/internal/utils/auth/validation"
 )
 
+// Config настройки сервиса лояльности
+type Config struct {
+	// Сколько ждать регистрации заказа в системе начислений, прежде чем перевести его в INVALID
+	NotRegisteredTimeout time.Duration
+	// Количество параллельных воркеров опроса системы начислений
+	AccrualWorkers int
+	// Идентификатор экземпляра сервиса, которым помечаются захваченные для опроса заказы
+	InstanceID string
+	// На сколько экземпляр захватывает заказ для опроса. По истечении заказ может забрать другой экземпляр
+	AccrualLeaseTimeout time.Duration
+	// Как часто сверять сохранённые балансы с журналом баллов (0 - не сверять)
+	LedgerCheckInterval time.Duration
+	// Сколько хранится ключ идемпотентности запроса на списание
+	IdempotencyKeyTTL time.Duration
+	// Через сколько сгорают начисленные баллы (0 - не сгорают)
+	PointsLifetime time.Duration
+	// Как часто искать сгоревшие партии баллов
+	PointsExpiryInterval time.Duration
+	// Уровни программы лояльности (пусто - уровни не используются)
+	Tiers models.Tiers
+	// За какой период суммируются начисления при расчёте уровня
+	TierWindow time.Duration
+	// Как часто пересчитывать уровни пользователей
+	TierRecomputeInterval time.Duration
+}
+
 type LoyaltyService struct {
 	//ВАЖНО: В Go интерфейсы УЖЕ ЯВЛЯЮТСЯ ССЫЛОЧНЫМ ТИПОМ (под капотом — указатель на структуру)
-	usersRepo       repository.IRepository[models.User]
-	ordersRepo      repository.IRepository[models.Order]
-	withdrawalsRepo repository.IRepository[models.Withdrawal]
+	usersRepo       repository.IUsersRepository
+	ordersRepo      repository.IOrdersRepository
+	withdrawalsRepo repository.IWithdrawalsRepository
+	ledgerRepo      repository.ILedgerRepository
+	idempotencyRepo repository.IIdempotencyRepository
+	pointLotsRepo   repository.IPointLotsRepository
+	campaignsRepo   repository.ICampaignsRepository
 	accrualClient   *accrual.Client
 	txManager       repository.ITransactionManager
 	taskDispatcher  *dispatcher.TaskDispatcher
+	passwordHasher  *password.Hasher
+	config          Config
 
-	pendingOrders chan string // Канал для новых заказов
+	accrualWakeup  chan struct{}      // Сигнал планировщику начислений о появлении новых заказов
+	inFlightOrders sync.Map           // Номера заказов, которые сейчас обрабатываются воркерами
+	stopWorkers    context.CancelFunc // Остановка фоновых воркеров
+	workersWG      sync.WaitGroup
 }
 
 var alreadyExistsError = customerrors.NewAlreadyExistsError(errors.New("entity already exists"))
 var notActuallyAnError = customerrors.NewOkError(errors.New("")) //Its a need
 var unprocessableEntityError = customerrors.NewUnprocessableEntityError(errors.New("unprocessable entity"))
 var paymentRequiredError = customerrors.NewPaymentRequiredError(errors.New("payment required"))
+var invalidCredentialsError = customerrors.NewUnauthorizedError(errors.New("invalid login or password"))
 
-func NewLoyaltyService(usersRepo repository.IRepository[models.User], ordersRepo repository.IRepository[models.Order], withdrawalsRepo repository.IRepository[models.Withdrawal], accrualClient *accrual.Client, txManager repository.ITransactionManager, taskDispatcher *dispatcher.TaskDispatcher) *LoyaltyService {
+func NewLoyaltyService(usersRepo repository.IUsersRepository, ordersRepo repository.IOrdersRepository, withdrawalsRepo repository.IWithdrawalsRepository, ledgerRepo repository.ILedgerRepository, idempotencyRepo repository.IIdempotencyRepository, pointLotsRepo repository.IPointLotsRepository, campaignsRepo repository.ICampaignsRepository, accrualClient *accrual.Client, txManager repository.ITransactionManager, taskDispatcher *dispatcher.TaskDispatcher, passwordHasher *password.Hasher, config Config) *LoyaltyService {
 	service := &LoyaltyService{
 		usersRepo:       usersRepo,
 		ordersRepo:      ordersRepo,
 		withdrawalsRepo: withdrawalsRepo,
+		ledgerRepo:      ledgerRepo,
+		idempotencyRepo: idempotencyRepo,
+		pointLotsRepo:   pointLotsRepo,
+		campaignsRepo:   campaignsRepo,
 		accrualClient:   accrualClient,
 		txManager:       txManager,
 		taskDispatcher:  taskDispatcher,
-		pendingOrders:   make(chan string, 300),
+		passwordHasher:  passwordHasher,
+		config:          config,
+		accrualWakeup:   make(chan struct{}, 1),
 	}
 
-	service.taskDispatcher.StartWorker(service.handleTask)
-	go service.ordersAccrualWorker()
+	service.taskDispatcher.StartWorker()
+
+	workersCtx, cancel := context.WithCancel(context.Background())
+	service.stopWorkers = cancel
+	service.startAccrualWorkers(workersCtx)
+	service.startLedgerChecker(workersCtx)
+	service.startIdempotencyCleaner(workersCtx)
+	service.startPointsExpiry(workersCtx)
+	service.startTierRecompute(workersCtx)
 
 	return service
 }
 
-// Обработчик задач (приватный метод)
-func (s *LoyaltyService) handleTask(task dispatcher.Task) (interface{}, error) {
-	switch task.Type {
-	case dispatcher.TaskCreateUser:
-		user := task.Payload.(*models.User)
-		return nil, s.createUser(task.Context, *user)
-	case dispatcher.TaskGetUser:
-		login := task.Payload.(string)
-		return s.usersRepo.Get(task.Context, login)
-	case dispatcher.TaskCreateOrder:
-		order := task.Payload.(*models.Order)
-		return nil, s.createOrder(task.Context, *order)
-	case dispatcher.TaskGetUserOrders:
-		login := task.Payload.(string)
-		return s.getUserOrders(task.Context, login)
-	case dispatcher.TaskCreateWithdrawal:
-		withdrawal := task.Payload.(*models.Withdrawal)
-		return nil, s.createWithdrawal(task.Context, *withdrawal)
-	case dispatcher.TaskGetUserWithdrawals:
-		login := task.Payload.(string)
-		return s.getUserWithdrawals(task.Context, login)
-	}
-	return nil, fmt.Errorf("unknown task type")
+// Shutdown выполняет уже принятые задачи, останавливает фоновые воркеры и дожидается завершения начатых
+// транзакций начисления. Ожидание ограничено ctx
+func (s *LoyaltyService) Shutdown(ctx context.Context) error {
+	dispatcherErr := s.taskDispatcher.Shutdown(ctx)
+
+	// Запросы к системе начислений прерываются сразу, транзакции записи результата - доводятся до конца
+	s.stopWorkers()
+
+	done := make(chan struct{})
+	go func() {
+		s.workersWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return fmt.Errorf("accrual workers did not stop in time: %w", ctx.Err())
+	}
+
+	if dispatcherErr != nil {
+		return fmt.Errorf("task queue was not drained in time: %w", dispatcherErr)
+	}
+	return nil
 }
 
+// Изменяющие операции идут через диспетчер с ключом-логином: операции одного пользователя выполняются по очереди.
+// Чтение выполняется напрямую и параллельно - согласованность изменений обеспечивают транзакции и ограничения БД
+
 func (s *LoyaltyService) CreateUser(ctx context.Context, newUser models.User) error {
-	_, err := s.taskDispatcher.Enqueue(dispatcher.Task{
-		Type:    dispatcher.TaskCreateUser,
-		Context: ctx,
-		Payload: &newUser,
-	})
+	// Хэширование намеренно медленное - выполняем до постановки в очередь, не занимая воркер диспетчера
+	hash, err := s.passwordHasher.Hash(newUser.Password)
+	if errors.Is(err, password.ErrTooLong) {
+		return customerrors.NewHTTPError(err, http.StatusBadRequest)
+	}
+	if err != nil {
+		return customerrors.NewInternalServerError(err)
+	}
+	newUser.Password = hash
 
-	return err
+	return dispatcher.Exec(ctx, s.taskDispatcher, newUser.Login, s.createUser, newUser)
 }
 
 func (s *LoyaltyService) GetUser(ctx context.Context, login string) (*models.User, error) {
-	res, err := s.taskDispatcher.Enqueue(dispatcher.Task{
-		Type:    dispatcher.TaskGetUser,
-		Context: ctx,
-		Payload: login,
-	})
-
-	return res.(*models.User), err
+	return s.usersRepo.Get(ctx, login)
 }
 
-func (s *LoyaltyService) CreateOrder(ctx context.Context, newOrder models.Order) error {
-	_, err := s.taskDispatcher.Enqueue(dispatcher.Task{
-		Type:    dispatcher.TaskCreateOrder,
-		Context: ctx,
-		Payload: &newOrder,
-	})
+// Authenticate проверяет логин и пароль. Хэши, сохранённые с устаревшими настройками (и пароли в открытом виде),
+// пересчитываются текущими настройками при успешном входе
+func (s *LoyaltyService) Authenticate(ctx context.Context, login string, pass string) (*models.User, error) {
+	user, err := s.usersRepo.Get(ctx, login)
+	if errors.Is(err, repository.ErrNotFound) {
+		// Время ответа не должно выдавать, существует ли пользователь
+		s.passwordHasher.Hash(pass)
+		return nil, invalidCredentialsError
+	}
+	if err != nil {
+		return nil, customerrors.NewInternalServerError(err)
+	}
 
-	return err
-}
+	ok, needsRehash, err := s.passwordHasher.Verify(user.Password, pass)
+	if err != nil {
+		return nil, customerrors.NewInternalServerError(err)
+	}
+	if !ok {
+		return nil, invalidCredentialsError
+	}
 
-func (s *LoyaltyService) GetUserOrders(ctx context.Context, login string) ([]models.Order, error) {
-	res, err := s.taskDispatcher.Enqueue(dispatcher.Task{
-		Type:    dispatcher.TaskGetUserOrders,
-		Context: ctx,
-		Payload: login,
-	})
+	if needsRehash {
+		s.rehashPassword(ctx, user, pass)
+	}
 
-	return res.([]models.Order), err
+	return user, nil
 }
 
-func (s *LoyaltyService) CreateWithdrawal(ctx context.Context, newWithdrawal models.Withdrawal) error {
-	_, err := s.taskDispatcher.Enqueue(dispatcher.Task{
-		Type:    dispatcher.TaskCreateWithdrawal,
-		Context: ctx,
-		Payload: &newWithdrawal,
-	})
+func (s *LoyaltyService) GetBalance(ctx context.Context, login string) (*models.Balance, error) {
+	return s.getBalance(ctx, login)
+}
 
-	return err
+func (s *LoyaltyService) CreateOrder(ctx context.Context, newOrder models.Order) error {
+	return dispatcher.Exec(ctx, s.taskDispatcher, newOrder.UserID, s.createOrder, newOrder)
+}
+
+func (s *LoyaltyService) GetUserOrders(ctx context.Context, login string, filter models.OrderFilter) (*models.Page[models.Order], error) {
+	return s.getUserOrders(ctx, login, filter)
 }
 
-func (s *LoyaltyService) GetUserWithdrawals(ctx context.Context, login string) ([]models.Withdrawal, error) {
-	res, err := s.taskDispatcher.Enqueue(dispatcher.Task{
-		Type:    dispatcher.TaskGetUserWithdrawals,
-		Context: ctx,
-		Payload: login,
+// CreateWithdrawal списывает баллы. Если передан ключ идемпотентности, повтор запроса с тем же ключом возвращает
+// статус первого ответа, не изменяя баланс
+func (s *LoyaltyService) CreateWithdrawal(ctx context.Context, newWithdrawal models.Withdrawal, idempotencyKey string) error {
+	if idempotencyKey == "" {
+		return dispatcher.Exec(ctx, s.taskDispatcher, newWithdrawal.UserID, s.createWithdrawal, newWithdrawal)
+	}
+
+	return dispatcher.Exec(ctx, s.taskDispatcher, newWithdrawal.UserID, s.createWithdrawalIdempotent, idempotentWithdrawal{
+		withdrawal: newWithdrawal,
+		key:        idempotencyKey,
 	})
+}
 
-	return res.([]models.Withdrawal), err
+func (s *LoyaltyService) GetUserWithdrawals(ctx context.Context, login string, filter models.WithdrawalFilter) (*models.Page[models.Withdrawal], error) {
+	return s.getUserWithdrawals(ctx, login, filter)
 }
 
 func (s *LoyaltyService) createUser(ctx context.Context, user models.User) error {
 
-	login := user.Login
-
-	// Проверка наличие логина в БД
-	existedUser, err := s.usersRepo.Get(ctx, login)
-	if err == nil && existedUser != nil {
+	// Уникальность логина проверяет первичный ключ (в том числе при нескольких экземплярах сервиса)
+	err := s.usersRepo.Create(ctx, &user)
+	if errors.Is(err, repository.ErrAlreadyExists) {
 		return alreadyExistsError
 	}
-
-	err = s.usersRepo.Create(ctx, &user)
 	if err != nil {
 		return err
 	}
@@ -152,6 +219,22 @@ func (s *LoyaltyService) createUser(ctx context.Context, user models.User) error
 	return nil
 }
 
+// rehashPassword сохраняет хэш пароля с текущими настройками. Ошибка не мешает входу - хэш обновится в следующий раз
+func (s *LoyaltyService) rehashPassword(ctx context.Context, user *models.User, pass string) {
+	hash, err := s.passwordHasher.Hash(pass)
+	if err != nil {
+		log.Printf("Failed to rehash password of user %s: %v", user.Login, err)
+		return
+	}
+
+	// Условие на старый хэш не даёт затереть пароль, изменённый параллельно
+	if _, err := s.usersRepo.UpdatePassword(ctx, user.Login, user.Password, hash); err != nil {
+		log.Printf("Failed to rehash password of user %s: %v", user.Login, err)
+		return
+	}
+	user.Password = hash
+}
+
 func (s *LoyaltyService) createOrder(ctx context.Context, order models.Order) error {
 
 	number := order.Number
@@ -160,23 +243,28 @@ func (s *LoyaltyService) createOrder(ctx context.Context, order models.Order) er
 		return unprocessableEntityError
 	}
 
-	// Проверка наличие заказа в БД
-	existedOrder, err := s.ordersRepo.Get(ctx, number)
-	if err == nil && existedOrder != nil {
+	// Уникальность номера проверяет первичный ключ: заказы разных пользователей создаются параллельно
+	err := s.ordersRepo.Create(ctx, &order)
+	if errors.Is(err, repository.ErrAlreadyExists) {
+		// Заказ уже загружен - выясняем, этим пользователем или другим
+		existedOrder, err := s.ordersRepo.Get(ctx, number)
+		if err != nil {
+			return err
+		}
 		if order.UserID == existedOrder.UserID {
 			return notActuallyAnError
-		} else {
-			return alreadyExistsError
 		}
+		return alreadyExistsError
 	}
-
-	err = s.ordersRepo.Create(ctx, &order)
 	if err != nil {
 		return err
 	}
 
-	// Добавляем заказ в очередь для записи начислений
-	s.pendingOrders <- order.Number
+	// Будим воркер начислений, не дожидаясь очередного тика
+	select {
+	case s.accrualWakeup <- struct{}{}:
+	default:
+	}
 
 	return nil
 }
@@ -185,157 +273,195 @@ func (s *LoyaltyService) createWithdrawal(ctx context.Context, withdrawal models
 
 	order := withdrawal.Order
 
-	if !validation.LuhnValidate(order) {
+	if !validation.LuhnValidate(order) || withdrawal.Sum <= 0 {
 		return unprocessableEntityError
 	}
 
-	// Проверка наличие заказа в БД
-	existedWithdrawal, err := s.withdrawalsRepo.Get(ctx, order)
-	if err == nil && existedWithdrawal != nil {
-		return unprocessableEntityError
-	}
-
-	user, err := s.usersRepo.Get(ctx, withdrawal.UserID)
-	if err != nil {
-		return err
-	}
-
-	if user.CurrentPoints < withdrawal.Sum {
-		return paymentRequiredError
-	}
-
-	//Добавляем списание и уменьшаем баланс паользователя в одной транзакции
-	err = s.txManager.RunInTransaction(ctx, func(ctx context.Context) error {
-		if err = s.withdrawalsRepo.Create(ctx, &withdrawal); err != nil {
+	//Добавляем списание и уменьшаем баланс паользователя в одной транзакции.
+	//Проверка остатка и списание выполняются одним условным UPDATE, а повторное списание по тому же заказу
+	//отсекается первичным ключом - никаких проверок "прочитал, потом записал" вне транзакции
+	err := s.txManager.RunInTransaction(ctx, func(ctx context.Context) error {
+		if err := s.withdrawalsRepo.Create(ctx, &withdrawal); err != nil {
 			return fmt.Errorf("failed to create withdrawal: %w", err)
 		}
 
-		//Изменяем баланс пользователя
-		user.CurrentPoints -= withdrawal.Sum
-		user.WithdrawnPoints += withdrawal.Sum
+		//Списанные баллы расходуем из самых старых партий. Партии блокируются раньше строки пользователя -
+		//в том же порядке, что и при сгорании баллов, чтобы транзакции не ждали друг друга по кругу
+		if err := s.pointLotsRepo.Consume(ctx, withdrawal.UserID, withdrawal.Sum); err != nil {
+			return fmt.Errorf("failed to consume point lots: %w", err)
+		}
 
-		if err := s.usersRepo.Update(ctx, user); err != nil {
-			return fmt.Errorf("failed to update profile: %w", err)
+		//Изменяем баланс пользователя
+		if err := s.ledgerRepo.Post(ctx, models.NewWithdrawalEntries(withdrawal.UserID, withdrawal.Order, withdrawal.Sum)); err != nil {
+			return fmt.Errorf("failed to update balance: %w", err)
 		}
 
 		return nil
 	})
 
-	if err != nil {
+	switch {
+	case errors.Is(err, repository.ErrAlreadyExists):
+		return unprocessableEntityError
+	case errors.Is(err, repository.ErrInsufficientFunds):
+		return paymentRequiredError
+	case err != nil:
 		return customerrors.NewInternalServerError(err)
 	}
 
 	return nil
 }
 
-func (s *LoyaltyService) getUserOrders(ctx context.Context, login string) ([]models.Order, error) {
-
-	orders, err := s.ordersRepo.GetAll(ctx)
-	if err != nil {
-		return nil, err
-	}
+// ReverseWithdrawal отменяет списание (например, если магазин отменил заказ): баллы возвращаются на текущий счёт
+func (s *LoyaltyService) ReverseWithdrawal(ctx context.Context, order string, reason string) (*models.Withdrawal, error) {
+	//Статус списания и возврат баллов меняются в одной транзакции. Повторная отмена отсекается условием на статус,
+	//а повторная проводка - уникальным идентификатором операции в журнале
+	var reversed *models.Withdrawal
+	now := time.Now()
+	err := s.txManager.RunInTransaction(ctx, func(ctx context.Context) error {
+		withdrawal, err := s.withdrawalsRepo.MarkReversed(ctx, order, reason, now)
+		if err != nil {
+			return err
+		}
+		reversed = withdrawal
 
-	var userOrders []models.Order
-	for _, order := range orders {
-		if order.UserID == login {
-			userOrders = append(userOrders, order)
+		//Возвращаем баллы пользователю
+		if err := s.ledgerRepo.Post(ctx, models.NewReversalEntries(withdrawal.UserID, withdrawal.Order, withdrawal.Sum)); err != nil {
+			return err
 		}
+
+		//Возвращённые баллы - новая партия: израсходованные партии могли уже сгореть
+		return s.pointLotsRepo.Add(ctx, &models.PointLot{
+			UserID:    withdrawal.UserID,
+			Reference: withdrawal.Order,
+			Amount:    withdrawal.Sum,
+			CreatedAt: now,
+			Expirable: true,
+		})
+	})
+
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return nil, customerrors.NewNotFoundError(err)
+	case errors.Is(err, repository.ErrAlreadyReversed):
+		return nil, customerrors.NewAlreadyExistsError(err)
+	case err != nil:
+		return nil, customerrors.NewInternalServerError(err)
 	}
 
-	return userOrders, nil
+	return reversed, nil
 }
 
-func (s *LoyaltyService) getUserWithdrawals(ctx context.Context, login string) ([]models.Withdrawal, error) {
-
-	withdrawals, err := s.withdrawalsRepo.GetAll(ctx)
-	if err != nil {
-		return nil, err
+// AdjustBalance вручную корректирует текущий баланс пользователя (компенсация, исправление ошибки) проводкой журнала.
+// reference - уникальный идентификатор корректировки (например, номер обращения): повтор с тем же reference отклоняется
+func (s *LoyaltyService) AdjustBalance(ctx context.Context, login string, reference string, amount models.Points) (*models.Balance, error) {
+	if reference == "" || amount == 0 {
+		return nil, unprocessableEntityError
 	}
 
-	var userWithdrawals []models.Withdrawal
-	for _, withdrawal := range withdrawals {
-		if withdrawal.UserID == login {
-			userWithdrawals = append(userWithdrawals, withdrawal)
+	now := time.Now()
+	err := s.txManager.RunInTransaction(ctx, func(ctx context.Context) error {
+		// Проводки несуществующего пользователя не попали бы в сохранённый баланс
+		if _, err := s.usersRepo.Get(ctx, login); err != nil {
+			return err
 		}
-	}
 
-	return userWithdrawals, nil
-}
+		//Списываемые баллы расходуем из самых старых партий - до блокировки строки пользователя, как и при списании
+		if amount < 0 {
+			if err := s.pointLotsRepo.Consume(ctx, login, -amount); err != nil {
+				return err
+			}
+		}
 
-func (s *LoyaltyService) ordersAccrualWorker() {
-	ticker := time.NewTicker(10 * time.Second) // Проверка каждые 10 секунд
-	defer ticker.Stop()
+		if err := s.ledgerRepo.Post(ctx, models.NewAdjustmentEntries(login, reference, amount)); err != nil {
+			return err
+		}
 
-	for {
-		select {
-		case orderNumber := <-s.pendingOrders:
-			s.checkOrderStatus(orderNumber)
-		case <-ticker.C:
-			//ждём...
+		if amount < 0 {
+			return nil
 		}
+
+		//Начисленные баллы - новая партия, сгорают на общих основаниях
+		return s.pointLotsRepo.Add(ctx, &models.PointLot{
+			UserID:    login,
+			Reference: reference,
+			Amount:    amount,
+			CreatedAt: now,
+			Expirable: true,
+		})
+	})
+
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return nil, customerrors.NewNotFoundError(err)
+	case errors.Is(err, repository.ErrAlreadyExists):
+		return nil, customerrors.NewAlreadyExistsError(err)
+	case errors.Is(err, repository.ErrInsufficientFunds):
+		return nil, customerrors.NewUnprocessableEntityError(err)
+	case err != nil:
+		return nil, customerrors.NewInternalServerError(err)
+	}
+
+	balance, err := s.getBalance(ctx, login)
+	if err != nil {
+		return nil, customerrors.NewInternalServerError(err)
 	}
+	return balance, nil
 }
 
-func (s *LoyaltyService) checkOrderStatus(orderNumber string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// getBalance рассчитывает баланс пользователя по журналу баллов. Сохранённый баланс используется как кэш:
+// досчитываются только проводки, которых в нём нет. Полностью снимок сверяется с журналом в CheckLedger
+func (s *LoyaltyService) getBalance(ctx context.Context, login string) (*models.Balance, error) {
+	balance, unaccounted, err := s.ledgerRepo.GetBalance(ctx, login)
+	if err != nil {
+		return nil, err
+	}
 
-	// Получаем текущий заказ
-	order, err := s.ordersRepo.Get(ctx, orderNumber)
-	if err != nil || order == nil {
-		return
+	// Проводки добавляются в одной транзакции с обновлением снимка - досчитанные проводки значат, что журнал менялся в обход
+	if unaccounted > 0 {
+		log.Printf("WARNING: balance snapshot of user %s misses %d ledger entries", login, unaccounted)
 	}
 
-	// Проверяем только нужные статусы
-	if order.Status != models.StatusNew && order.Status != models.StatusProcessing {
-		return
+	return balance, nil
+}
+
+func (s *LoyaltyService) getUserOrders(ctx context.Context, login string, filter models.OrderFilter) (*models.Page[models.Order], error) {
+	limit := filter.Limit
+	if limit > 0 {
+		filter.Limit++ // Лишняя запись показывает, есть ли следующая страница
 	}
 
-	// Запрашиваем обновление статуса
-	orderInfo, err := s.accrualClient.GetOrderInfo(ctx, orderNumber)
+	orders, err := s.ordersRepo.GetByUser(ctx, login, filter)
 	if err != nil {
-		log.Printf("Failed to check order %s: %v", orderNumber, err)
-		time.Sleep(3 * time.Second)    // Блокируем текущую горутину
-		s.pendingOrders <- orderNumber // Повторяем позже
-		return
+		return nil, err
 	}
 
-	//Добавляем начисления и увеличиваем баланс паользователя в одной транзакции
-	err = s.txManager.RunInTransaction(ctx, func(ctx context.Context) error {
-		// Обновляем заказ
-		updatedOrder := models.Order{
-			UserID:     order.UserID,
-			Number:     orderNumber,
-			Status:     models.Status(orderInfo.Status),
-			Accrual:    orderInfo.Accrual,
-			UploadedAt: order.UploadedAt,
-		}
+	return newPage(orders, limit, func(order models.Order) models.Cursor {
+		return models.Cursor{At: order.UploadedAt, Key: order.Number}
+	}), nil
+}
 
-		if err := s.ordersRepo.Update(ctx, &updatedOrder); err != nil {
-			return err
-		}
+func (s *LoyaltyService) getUserWithdrawals(ctx context.Context, login string, filter models.WithdrawalFilter) (*models.Page[models.Withdrawal], error) {
+	limit := filter.Limit
+	if limit > 0 {
+		filter.Limit++
+	}
 
-		// Если статус ещё не финальный - продолжаем проверять
-		if updatedOrder.Status == models.StatusNew || updatedOrder.Status == models.StatusProcessing {
-			time.Sleep(3 * time.Second) // Блокируем текущую горутину
-			s.pendingOrders <- orderNumber
-		} else {
-			// Обновляем баланс пользователя
-			user, err := s.usersRepo.Get(ctx, order.UserID)
-			if err != nil {
-				return err
-			}
-			user.CurrentPoints += updatedOrder.Accrual
-			err = s.usersRepo.Update(ctx, user)
-			if err != nil {
-				return err
-			}
-		}
+	withdrawals, err := s.withdrawalsRepo.GetByUser(ctx, login, filter)
+	if err != nil {
+		return nil, err
+	}
 
-		return nil
-	})
+	return newPage(withdrawals, limit, func(withdrawal models.Withdrawal) models.Cursor {
+		return models.Cursor{At: withdrawal.ProcessedAt, Key: withdrawal.Order}
+	}), nil
+}
 
-	if err != nil {
-		log.Printf("Failed to update order %s: %v", orderNumber, err)
+// newPage обрезает выборку до limit записей. Если записей больше, курсор следующей страницы указывает на последнюю выданную
+func newPage[T any](items []T, limit int, cursorOf func(T) models.Cursor) *models.Page[T] {
+	page := &models.Page[T]{Items: items}
+	if limit > 0 && len(items) > limit {
+		page.Items = items[:limit]
+		page.NextCursor = cursorOf(page.Items[limit-1]).Encode()
 	}
+	return page
 }
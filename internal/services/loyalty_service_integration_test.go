@@ -0,0 +1,475 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JustScorpio/loyalty_system/internal/accrual"
+	"github.com/JustScorpio/loyalty_system/internal/customerrors"
+	"github.com/JustScorpio/loyalty_system/internal/infrastructure"
+	"github.com/JustScorpio/loyalty_system/internal/models"
+	"github.com/JustScorpio/loyalty_system/internal/repository/postgres"
+	"github.com/JustScorpio/loyalty_system/internal/repository/postgres/migrations"
+	"github.com/JustScorpio/loyalty_system/internal/services"
+	"github.com/JustScorpio/loyalty_system/internal/utils/auth"
+	"github.com/JustScorpio/loyalty_system/internal/utils/auth/password"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Интеграционные тесты работают с настоящей БД: DATABASE_URI=postgres://... go test ./internal/services/
+
+func openTestDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	connStr := os.Getenv("DATABASE_URI")
+	if connStr == "" {
+		t.Skip("DATABASE_URI is not set")
+	}
+
+	db, err := postgres.NewDBPool(connStr, postgres.PoolConfig{MaxConns: 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+
+	if _, err := migrations.Up(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestService создаёт экземпляр сервиса поверх общей БД. Несколько экземпляров - как несколько реплик:
+// у каждого свой диспетчер задач, поэтому их операции над одним пользователем идут параллельно
+func newTestService(t *testing.T, db *pgxpool.Pool, accrualURL string) *services.LoyaltyService {
+	t.Helper()
+
+	hasher, err := password.NewHasher(password.AlgorithmBcrypt, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := services.NewLoyaltyService(
+		postgres.NewPgUsersRepo(db),
+		postgres.NewPgOrdersRepo(db),
+		postgres.NewPgWithdrawalsRepo(db),
+		postgres.NewPgLedgerRepo(db),
+		postgres.NewPgIdempotencyRepo(db),
+		postgres.NewPgPointLotsRepo(db),
+		postgres.NewPgCampaignsRepo(db),
+		accrual.NewClient(accrualURL, time.Second, 0),
+		postgres.NewPgxTransactionManager(db),
+		infrastructure.NewTaskDispatcher(4, 100),
+		hasher,
+		services.Config{
+			NotRegisteredTimeout: time.Hour,
+			AccrualWorkers:       2,
+			InstanceID:           fmt.Sprintf("%s-%d", t.Name(), time.Now().UnixNano()),
+			AccrualLeaseTimeout:  time.Minute,
+		},
+	)
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		service.Shutdown(ctx)
+	})
+	return service
+}
+
+// newUser создаёт пользователя с уникальным логином и начисляет ему balance баллов
+func newUser(t *testing.T, db *pgxpool.Pool, service *services.LoyaltyService, balance models.Points) string {
+	t.Helper()
+	ctx := context.Background()
+
+	login := fmt.Sprintf("it-%s-%d", t.Name(), time.Now().UnixNano())
+	if err := service.CreateUser(ctx, models.User{Login: login, Password: "password"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if balance > 0 {
+		ledgerRepo := postgres.NewPgLedgerRepo(db)
+		err := postgres.NewPgxTransactionManager(db).RunInTransaction(ctx, func(ctx context.Context) error {
+			return ledgerRepo.Post(ctx, models.NewAccrualEntries(login, "seed-"+login, balance))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return login
+}
+
+// orderNumber уникальный номер заказа с верной контрольной цифрой Луна
+func orderNumber(seq int) string {
+	base := strconv.FormatInt(time.Now().UnixNano()%1e12, 10) + fmt.Sprintf("%04d", seq)
+
+	sum := 0
+	for i := len(base) - 1; i >= 0; i-- {
+		digit := int(base[i] - '0')
+		// Контрольная цифра добавляется справа - удваиваются цифры на нечётных позициях с конца
+		if (len(base)-i)%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+	return base + strconv.Itoa((10-sum%10)%10)
+}
+
+func statusCode(err error) int {
+	var httpErr *customerrors.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Code
+	}
+	return 0
+}
+
+// assertLedgerConsistent проверяет, что сохранённые балансы пользователей совпадают с журналом
+func assertLedgerConsistent(t *testing.T, service *services.LoyaltyService, logins ...string) {
+	t.Helper()
+
+	discrepancies, err := service.CheckLedger(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range discrepancies {
+		for _, login := range logins {
+			if d.UserID == login {
+				t.Errorf("balance of %s does not match ledger: snapshot %+v, ledger %+v", login, d.Snapshot, d.Ledger)
+			}
+		}
+	}
+}
+
+func TestParallelWithdrawalsDoNotOverdraw(t *testing.T) {
+	db := openTestDB(t)
+	replicas := []*services.LoyaltyService{
+		newTestService(t, db, "http://127.0.0.1:0"),
+		newTestService(t, db, "http://127.0.0.1:0"),
+		newTestService(t, db, "http://127.0.0.1:0"),
+	}
+
+	const (
+		balance     = models.Points(100000) // 1000 баллов
+		sum         = models.Points(15000)  // 150 баллов
+		withdrawals = 20
+	)
+	login := newUser(t, db, replicas[0], balance)
+
+	var wg sync.WaitGroup
+	errs := make([]error, withdrawals)
+	start := make(chan struct{})
+	for i := 0; i < withdrawals; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = replicas[i%len(replicas)].CreateWithdrawal(context.Background(), models.Withdrawal{
+				UserID:      login,
+				Order:       orderNumber(i),
+				Sum:         sum,
+				ProcessedAt: time.Now(),
+			}, "")
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	succeeded, rejected := 0, 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case statusCode(err) == http.StatusPaymentRequired:
+			rejected++
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	wantSucceeded := int(balance / sum)
+	if succeeded != wantSucceeded || rejected != withdrawals-wantSucceeded {
+		t.Errorf("succeeded %d, rejected with 402 %d; want %d and %d", succeeded, rejected, wantSucceeded, withdrawals-wantSucceeded)
+	}
+
+	got, err := replicas[0].GetBalance(context.Background(), login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantWithdrawn := sum * models.Points(wantSucceeded)
+	if got.Current != balance-wantWithdrawn || got.Withdrawn != wantWithdrawn {
+		t.Errorf("balance %+v, want current %v withdrawn %v", got, balance-wantWithdrawn, wantWithdrawn)
+	}
+
+	assertLedgerConsistent(t, replicas[0], login)
+}
+
+func TestAccrualRacingWithWithdrawal(t *testing.T) {
+	db := openTestDB(t)
+
+	const (
+		balance = models.Points(10000) // 100 баллов
+		accrued = models.Points(10000) // 100 баллов по заказу
+		sum     = models.Points(15000) // 150 баллов - проходит, только если начисление успело раньше
+	)
+
+	accrualServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"order":%q,"status":"PROCESSED","accrual":%s}`, r.URL.Path[len("/api/orders/"):], accrued)
+	}))
+	defer accrualServer.Close()
+
+	accrualReplica := newTestService(t, db, accrualServer.URL)
+	withdrawalReplica := newTestService(t, db, accrualServer.URL)
+
+	for attempt := 0; attempt < 5; attempt++ {
+		login := newUser(t, db, accrualReplica, balance)
+		order := orderNumber(2 * attempt)
+
+		var wg sync.WaitGroup
+		var withdrawalErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := accrualReplica.CreateOrder(context.Background(), *models.NewOrder(login, order)); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			withdrawalErr = withdrawalReplica.CreateWithdrawal(context.Background(), models.Withdrawal{
+				UserID:      login,
+				Order:       orderNumber(2*attempt + 1),
+				Sum:         sum,
+				ProcessedAt: time.Now(),
+			}, "")
+		}()
+		wg.Wait()
+
+		if withdrawalErr != nil && statusCode(withdrawalErr) != http.StatusPaymentRequired {
+			t.Fatalf("unexpected withdrawal error: %v", withdrawalErr)
+		}
+
+		waitOrderProcessed(t, accrualReplica, login, order)
+
+		got, err := accrualReplica.GetBalance(context.Background(), login)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := models.Balance{Current: balance + accrued}
+		if withdrawalErr == nil {
+			want = models.Balance{Current: balance + accrued - sum, Withdrawn: sum}
+		}
+		if *got != want {
+			t.Errorf("attempt %d: balance %+v, want %+v (withdrawal error %v)", attempt, got, want, withdrawalErr)
+		}
+
+		assertLedgerConsistent(t, accrualReplica, login)
+	}
+}
+
+func waitOrderProcessed(t *testing.T, service *services.LoyaltyService, login string, number string) {
+	t.Helper()
+
+	deadline := time.Now().Add(15 * time.Second)
+	for time.Now().Before(deadline) {
+		page, err := service.GetUserOrders(context.Background(), login, models.OrderFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, order := range page.Items {
+			if order.Number == number && order.Status == models.StatusProcessed {
+				return
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("order %s was not processed in time", number)
+}
+
+func TestStaleLeaseOwnerCannotUpdateOrder(t *testing.T) {
+	db := openTestDB(t)
+	service := newTestService(t, db, "http://127.0.0.1:0")
+	ordersRepo := postgres.NewPgOrdersRepo(db)
+	ctx := context.Background()
+
+	login := newUser(t, db, service, 0)
+	order := models.NewOrder(login, orderNumber(0))
+	// Опрос заказа отложен, чтобы его не захватили фоновые воркеры тестового экземпляра
+	order.NextAttemptAt = order.UploadedAt.Add(time.Hour)
+	if err := ordersRepo.Create(ctx, order); err != nil {
+		t.Fatal(err)
+	}
+
+	// Экземпляр stale захватывает заказ, его захват истекает, и заказ забирает экземпляр owner
+	now := order.NextAttemptAt
+	claim := func(owner string, at time.Time) {
+		t.Helper()
+		orders, err := ordersRepo.ClaimPending(ctx, owner, at, at.Add(time.Minute), 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, claimed := range orders {
+			if claimed.Number == order.Number {
+				return
+			}
+		}
+		t.Fatalf("%s did not claim order %s", owner, order.Number)
+	}
+	claim(t.Name()+"-stale", now)
+	claim(t.Name()+"-owner", now.Add(2*time.Minute))
+
+	stale := *order
+	stale.Attempts = 5
+	stale.NextAttemptAt = now.Add(time.Hour)
+	updated, err := ordersRepo.UpdatePending(ctx, t.Name()+"-stale", &stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated {
+		t.Error("instance with an expired lease updated the order")
+	}
+
+	updated, err = ordersRepo.UpdatePending(ctx, t.Name()+"-owner", order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated {
+		t.Error("current lease owner could not update the order")
+	}
+}
+
+func TestBalanceAdjustmentsAreReadFromLedger(t *testing.T) {
+	db := openTestDB(t)
+	service := newTestService(t, db, "http://127.0.0.1:0")
+	ctx := context.Background()
+
+	login := newUser(t, db, service, 10000) // 100 баллов
+
+	got, err := service.AdjustBalance(ctx, login, "fix-1", 2500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Current != 12500 {
+		t.Errorf("balance after adjustment %+v, want current 12500", got)
+	}
+
+	// Повторная корректировка с тем же идентификатором не проводится второй раз
+	if _, err := service.AdjustBalance(ctx, login, "fix-1", 2500); statusCode(err) != http.StatusConflict {
+		t.Errorf("repeated adjustment: got %v, want 409", err)
+	}
+	// Списать больше текущего баланса нельзя
+	if _, err := service.AdjustBalance(ctx, login, "fix-2", -20000); statusCode(err) != http.StatusUnprocessableEntity {
+		t.Errorf("overdrawing adjustment: got %v, want 422", err)
+	}
+
+	got, err = service.AdjustBalance(ctx, login, "fix-3", -500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Current != 12000 {
+		t.Errorf("balance after negative adjustment %+v, want current 12000", got)
+	}
+
+	// Проводка в обход снимка баланса всё равно попадает в баланс - он считается по журналу
+	_, err = db.Exec(ctx, `INSERT INTO ledger (txid, userid, account, kind, amount, reference)
+		VALUES ($1, $2, 'CURRENT', 'ADJUSTMENT', 1, $1)`, "ADJUSTMENT:it-bypass-"+login, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = service.GetBalance(ctx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Current != 12100 {
+		t.Errorf("balance %+v does not include the ledger entry missing from the snapshot", got)
+	}
+}
+
+func TestReusedRefreshTokenRevokesSession(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	key, err := auth.NewRandomHMACKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := auth.NewTokenManager(time.Minute, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := services.NewSessionService(postgres.NewPgSessionsRepo(db), tokens, time.Hour)
+	t.Cleanup(func() { sessions.Shutdown(context.Background()) })
+
+	login := fmt.Sprintf("it-%s-%d", t.Name(), time.Now().UnixNano())
+	first, err := sessions.Start(ctx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := sessions.Refresh(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Уже обновлённый токен предъявлен повторно - сессия отзывается целиком
+	if _, err := sessions.Refresh(ctx, first.RefreshToken); statusCode(err) != http.StatusUnauthorized {
+		t.Fatalf("reused refresh token: got %v, want 401", err)
+	}
+	if _, err := sessions.Refresh(ctx, second.RefreshToken); statusCode(err) != http.StatusUnauthorized {
+		t.Errorf("latest refresh token of a revoked session: got %v, want 401", err)
+	}
+	if _, err := sessions.VerifyAccessToken(ctx, second.AccessToken); statusCode(err) != http.StatusUnauthorized {
+		t.Errorf("access token of a revoked session: got %v, want 401", err)
+	}
+}
+
+func TestUpdateTiersProcessesLoginRanges(t *testing.T) {
+	db := openTestDB(t)
+	service := newTestService(t, db, "http://127.0.0.1:0")
+	usersRepo := postgres.NewPgUsersRepo(db)
+	ctx := context.Background()
+
+	logins := []string{
+		newUser(t, db, service, 10000),
+		newUser(t, db, service, 10000),
+		newUser(t, db, service, 10000),
+	}
+	tiers := models.Tiers{{Name: "base"}, {Name: "gold", Threshold: 5000}}
+	now := time.Now()
+
+	// Логины пользователей теста начинаются с общего префикса и идут по возрастанию
+	after := fmt.Sprintf("it-%s-", t.Name())
+	changes, last, err := usersRepo.UpdateTiers(ctx, tiers, now.Add(-time.Hour), now, after, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != logins[1] || len(changes) != 2 {
+		t.Fatalf("first range ends at %q with %d changes, want %q and 2", last, len(changes), logins[1])
+	}
+
+	user, err := usersRepo.Get(ctx, logins[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Tier != "" {
+		t.Errorf("user outside the range got tier %q", user.Tier)
+	}
+
+	changes, _, err = usersRepo.UpdateTiers(ctx, tiers, now.Add(-time.Hour), now, last, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].UserID != logins[2] || changes[0].To != "gold" {
+		t.Errorf("second range changes %+v, want %s promoted to gold", changes, logins[2])
+	}
+}
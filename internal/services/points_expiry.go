@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/JustScorpio/loyalty_system/internal/models"
+)
+
+// Сколько пользователей с просроченными партиями выбирается за один запрос
+const pointsExpiryBatchSize = 100
+
+// startPointsExpiry периодически списывает остатки партий баллов, срок жизни которых истёк
+func (s *LoyaltyService) startPointsExpiry(ctx context.Context) {
+	if s.config.PointsLifetime <= 0 || s.config.PointsExpiryInterval <= 0 {
+		return
+	}
+
+	s.workersWG.Add(1)
+	go func() {
+		defer s.workersWG.Done()
+
+		ticker := time.NewTicker(s.config.PointsExpiryInterval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := s.ExpirePoints(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Points expiry failed: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// ExpirePoints списывает остатки всех просроченных партий и возвращает количество сгоревших партий
+func (s *LoyaltyService) ExpirePoints(ctx context.Context) (int, error) {
+	now := time.Now()
+	createdBefore := now.Add(-s.config.PointsLifetime)
+
+	total := 0
+	after := ""
+	for {
+		users, err := s.pointLotsRepo.FindUsersWithExpired(ctx, createdBefore, after, pointsExpiryBatchSize)
+		if err != nil {
+			return total, err
+		}
+
+		for _, userID := range users {
+			expired, err := s.expireUserPoints(ctx, userID, createdBefore, now)
+			total += expired
+			if err != nil {
+				if ctx.Err() != nil {
+					return total, ctx.Err()
+				}
+				log.Printf("Failed to expire points of user %s: %v", userID, err)
+			}
+		}
+
+		if len(users) < pointsExpiryBatchSize {
+			return total, nil
+		}
+		after = users[len(users)-1]
+	}
+}
+
+// expireUserPoints сжигает просроченные партии одного пользователя в своей транзакции: она блокирует только
+// его партии и строку, поэтому не может взаимно заблокироваться с операциями над другими пользователями.
+// Каждая партия сгорает во вложенной транзакции: ошибка по одной партии не мешает остальным
+func (s *LoyaltyService) expireUserPoints(ctx context.Context, userID string, createdBefore time.Time, now time.Time) (expired int, err error) {
+	err = s.txManager.RunInTransaction(ctx, func(ctx context.Context) error {
+		lots, err := s.pointLotsRepo.ClaimExpired(ctx, userID, createdBefore)
+		if err != nil {
+			return err
+		}
+
+		for _, lot := range lots {
+			if err := s.txManager.RunInTransaction(ctx, func(ctx context.Context) error {
+				return s.expireLot(ctx, lot, now)
+			}); err != nil {
+				log.Printf("Failed to expire points lot %d of user %s: %v", lot.ID, lot.UserID, err)
+				continue
+			}
+			expired++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return expired, nil
+}
+
+// expireLot списывает остаток партии с текущего счёта пользователя на счёт сгоревших баллов
+func (s *LoyaltyService) expireLot(ctx context.Context, lot models.PointLot, now time.Time) error {
+	// Сгорает не больше текущего баланса: баллы, потраченные до введения партий, могли к ним не привязаться
+	user, err := s.usersRepo.Get(ctx, lot.UserID)
+	if err != nil {
+		return err
+	}
+	amount := max(min(lot.Remaining, user.CurrentPoints), 0)
+
+	if amount > 0 {
+		err := s.ledgerRepo.Post(ctx, models.NewExpiryEntries(lot.UserID, "LOT:"+strconv.FormatInt(lot.ID, 10), amount))
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.pointLotsRepo.MarkExpired(ctx, lot.ID, amount, now)
+}
+
+// GetExpiringPoints возвращает баллы пользователя, которые сгорят в ближайшие within, в порядке сгорания
+func (s *LoyaltyService) GetExpiringPoints(ctx context.Context, login string, within time.Duration) ([]models.ExpiringPoints, error) {
+	if s.config.PointsLifetime <= 0 {
+		return []models.ExpiringPoints{}, nil
+	}
+
+	lots, err := s.pointLotsRepo.GetExpiring(ctx, login, time.Now().Add(within-s.config.PointsLifetime))
+	if err != nil {
+		return nil, err
+	}
+
+	expiring := make([]models.ExpiringPoints, 0, len(lots))
+	for _, lot := range lots {
+		expiring = append(expiring, models.ExpiringPoints{
+			Amount:    lot.Remaining,
+			ExpiresAt: lot.ExpiresAt(s.config.PointsLifetime),
+		})
+	}
+
+	return expiring, nil
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/JustScorpio/loyalty_system/internal/customerrors"
+	"github.com/JustScorpio/loyalty_system/internal/models"
+	"github.com/JustScorpio/loyalty_system/internal/repository"
+	"github.com/JustScorpio/loyalty_system/internal/utils/auth"
+)
+
+var invalidTokenError = customerrors.NewUnauthorizedError(errors.New("invalid or revoked token"))
+
+// TokenPair токены новой или обновлённой сессии
+type TokenPair struct {
+	AccessToken      string
+	AccessExpiresIn  time.Duration
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+// SessionService сессии пользователей: выдача пар токенов, обновление по refresh-токену и отзыв
+type SessionService struct {
+	sessionsRepo    repository.ISessionsRepository
+	tokens          *auth.TokenManager
+	refreshLifetime time.Duration
+
+	stopCleaner context.CancelFunc // Остановка очистки сессий
+	cleanerWG   sync.WaitGroup
+}
+
+func NewSessionService(sessionsRepo repository.ISessionsRepository, tokens *auth.TokenManager, refreshLifetime time.Duration) *SessionService {
+	service := &SessionService{
+		sessionsRepo:    sessionsRepo,
+		tokens:          tokens,
+		refreshLifetime: refreshLifetime,
+	}
+
+	cleanerCtx, cancel := context.WithCancel(context.Background())
+	service.stopCleaner = cancel
+	service.startCleaner(cleanerCtx)
+
+	return service
+}
+
+// Shutdown останавливает очистку сессий и дожидается её завершения
+func (s *SessionService) Shutdown(ctx context.Context) error {
+	s.stopCleaner()
+
+	done := make(chan struct{})
+	go func() {
+		s.cleanerWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startCleaner периодически удаляет истёкшие и отозванные сессии
+func (s *SessionService) startCleaner(ctx context.Context) {
+	if s.refreshLifetime <= 0 {
+		return
+	}
+
+	s.cleanerWG.Add(1)
+	go func() {
+		defer s.cleanerWG.Done()
+
+		ticker := time.NewTicker(min(s.refreshLifetime, time.Hour))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if _, err := s.sessionsRepo.DeleteInactive(ctx, time.Now()); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to delete inactive sessions: %v", err)
+			}
+		}
+	}()
+}
+
+// Start открывает новую сессию пользователя
+func (s *SessionService) Start(ctx context.Context, login string) (*TokenPair, error) {
+	sessionID, err := auth.NewSessionID()
+	if err != nil {
+		return nil, customerrors.NewInternalServerError(err)
+	}
+	refreshToken, refreshHash, err := auth.NewRefreshToken()
+	if err != nil {
+		return nil, customerrors.NewInternalServerError(err)
+	}
+
+	now := time.Now()
+	session := models.Session{
+		ID:          sessionID,
+		UserID:      login,
+		RefreshHash: refreshHash,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.refreshLifetime),
+	}
+	if err := s.sessionsRepo.Create(ctx, &session); err != nil {
+		return nil, customerrors.NewInternalServerError(err)
+	}
+
+	return s.tokenPair(&session, refreshToken)
+}
+
+// Refresh выдаёт новую пару токенов по refresh-токену. Старый refresh-токен после этого недействителен,
+// а его повторное предъявление отзывает всю сессию: токен мог быть украден, и неизвестно, кто из двоих владелец
+func (s *SessionService) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	newToken, newHash, err := auth.NewRefreshToken()
+	if err != nil {
+		return nil, customerrors.NewInternalServerError(err)
+	}
+
+	now := time.Now()
+	oldHash := auth.HashRefreshToken(refreshToken)
+	session, err := s.sessionsRepo.Rotate(ctx, oldHash, newHash, now, now.Add(s.refreshLifetime))
+	if errors.Is(err, repository.ErrNotFound) {
+		sessionID, revokeErr := s.sessionsRepo.RevokeByUsedToken(ctx, oldHash, now)
+		if revokeErr == nil {
+			log.Printf("WARNING: reused refresh token of session %s, the session is revoked", sessionID)
+		} else if !errors.Is(revokeErr, repository.ErrNotFound) {
+			return nil, customerrors.NewInternalServerError(revokeErr)
+		}
+		return nil, invalidTokenError
+	}
+	if err != nil {
+		return nil, customerrors.NewInternalServerError(err)
+	}
+
+	return s.tokenPair(session, newToken)
+}
+
+// Revoke отзывает сессию пользователя (выход)
+func (s *SessionService) Revoke(ctx context.Context, login string, sessionID string) error {
+	return s.sessionsRepo.Revoke(ctx, login, sessionID, time.Now())
+}
+
+// RevokeAll отзывает все сессии пользователя (выход на всех устройствах)
+func (s *SessionService) RevokeAll(ctx context.Context, login string) error {
+	return s.sessionsRepo.RevokeAll(ctx, login, time.Now())
+}
+
+// VerifyAccessToken проверяет подпись и срок токена доступа, а также то, что его сессия не отозвана
+func (s *SessionService) VerifyAccessToken(ctx context.Context, token string) (*auth.Claims, error) {
+	claims, err := s.tokens.ParseToken(token)
+	if err != nil {
+		return nil, invalidTokenError
+	}
+
+	active, err := s.sessionsRepo.IsActive(ctx, claims.SessionID, time.Now())
+	if err != nil {
+		return nil, customerrors.NewInternalServerError(err)
+	}
+	if !active {
+		return nil, invalidTokenError
+	}
+
+	return claims, nil
+}
+
+func (s *SessionService) tokenPair(session *models.Session, refreshToken string) (*TokenPair, error) {
+	accessToken, err := s.tokens.GenerateToken(session.UserID, session.ID)
+	if err != nil {
+		return nil, customerrors.NewInternalServerError(err)
+	}
+
+	return &TokenPair{
+		AccessToken:      accessToken,
+		AccessExpiresIn:  s.tokens.Lifetime(),
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: session.ExpiresAt,
+	}, nil
+}
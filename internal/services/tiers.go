@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/JustScorpio/loyalty_system/internal/customerrors"
+	"github.com/JustScorpio/loyalty_system/internal/models"
+)
+
+// Сколько пользователей пересчитывается одной командой
+const tierRecomputeBatchSize = 1000
+
+var tiersDisabledError = customerrors.NewNotFoundError(errors.New("loyalty tiers are disabled"))
+
+// startTierRecompute периодически пересчитывает уровни пользователей по начислениям за скользящее окно
+func (s *LoyaltyService) startTierRecompute(ctx context.Context) {
+	if len(s.config.Tiers) == 0 || s.config.TierRecomputeInterval <= 0 {
+		return
+	}
+
+	s.workersWG.Add(1)
+	go func() {
+		defer s.workersWG.Done()
+
+		ticker := time.NewTicker(s.config.TierRecomputeInterval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := s.RecomputeTiers(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Tier recompute failed: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RecomputeTiers повышает и понижает уровни пользователей и пишет изменения в лог.
+// Пользователи обрабатываются порциями по логину, каждая порция - отдельная команда со своими блокировками
+func (s *LoyaltyService) RecomputeTiers(ctx context.Context) ([]models.TierChange, error) {
+	now := time.Now()
+
+	var changes []models.TierChange
+	after := ""
+	for {
+		batch, last, err := s.usersRepo.UpdateTiers(ctx, s.config.Tiers, now.Add(-s.config.TierWindow), now, after, tierRecomputeBatchSize)
+		if err != nil {
+			return changes, err
+		}
+
+		for _, change := range batch {
+			log.Printf("Tier of user %s changed: %q -> %q", change.UserID, change.From, change.To)
+		}
+		changes = append(changes, batch...)
+
+		if last == "" {
+			return changes, nil
+		}
+		after = last
+	}
+}
+
+// GetTierProgress возвращает уровень пользователя и сколько баллов начислено за окно для перехода на следующий
+func (s *LoyaltyService) GetTierProgress(ctx context.Context, login string) (*models.TierProgress, error) {
+	if len(s.config.Tiers) == 0 {
+		return nil, tiersDisabledError
+	}
+
+	user, err := s.usersRepo.Get(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+
+	accrued, err := s.ledgerRepo.GetAccruedSince(ctx, login, time.Now().Add(-s.config.TierWindow))
+	if err != nil {
+		return nil, err
+	}
+
+	progress := &models.TierProgress{
+		Tier:    s.config.Tiers.Lookup(user.Tier),
+		Accrued: accrued,
+	}
+	if next, ok := s.config.Tiers.Next(user.Tier); ok {
+		progress.Next = &next
+	}
+
+	return progress, nil
+}
+
+// userTier уровень, действующий для пользователя (пусто - уровни не используются)
+func (s *LoyaltyService) userTier(user *models.User) string {
+	if len(s.config.Tiers) == 0 {
+		return ""
+	}
+	return s.config.Tiers.Lookup(user.Tier).Name
+}
+
+// postTierBonus начисляет бонус уровня пользователя к начислению по заказу и возвращает его размер.
+// Действует уровень, рассчитанный последним пересчётом
+func (s *LoyaltyService) postTierBonus(ctx context.Context, user *models.User, order models.Order) (models.Points, error) {
+	if len(s.config.Tiers) == 0 {
+		return 0, nil
+	}
+
+	bonus := s.config.Tiers.Lookup(user.Tier).Bonus(order.Accrual)
+	if bonus <= 0 {
+		return 0, nil
+	}
+
+	return bonus, s.ledgerRepo.Post(ctx, models.NewTierBonusEntries(user.Login, order.Number, bonus))
+}
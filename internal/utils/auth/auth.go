@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -9,51 +11,108 @@ import (
 const (
 	// Имя куки с JWT-токеном
 	JwtCookieName = "jwt_token"
-	//Время жизни токена
-	TokenLifeTime = time.Hour * 3
-	// Ключ для генерации и расшифровки токена (В РЕАЛЬНОМ ПРИЛОЖЕНИИ ХРАНИТЬ В НАДЁЖНОМ МЕСТЕ)
-	secretKey = "supersecretkey"
+	// Имя куки с refresh-токеном
+	RefreshCookieName = "refresh_token"
+	// Префикс токена в заголовке Authorization
+	BearerPrefix = "Bearer "
 )
 
-// Claims — структура утверждений, которая включает стандартные утверждения и одно пользовательское UserID
+var errUnknownKey = errors.New("token is signed with an unknown key")
+var errNoSession = errors.New("token is not bound to a session")
+
+// Claims — структура утверждений, которая включает стандартные утверждения, UserID и идентификатор сессии,
+// по которому токен можно отозвать до истечения срока
 type Claims struct {
 	jwt.RegisteredClaims
-	UserID string
+	UserID    string
+	SessionID string `json:"sid"`
+}
+
+// TokenManager выпускает токены текущим ключом подписи и проверяет токены любым из активных ключей.
+// Ключ выбирается по заголовку kid, поэтому при ротации старые токены остаются действительными до истечения срока,
+// пока предыдущий ключ числится среди ключей проверки
+type TokenManager struct {
+	lifetime time.Duration // Время жизни токена доступа
+	signing  *Key
+	keys     map[string]*Key
+	methods  []string
+}
+
+// NewTokenManager создаёт менеджер токенов. Ключ подписи автоматически используется и для проверки
+func NewTokenManager(lifetime time.Duration, signing *Key, verification ...*Key) (*TokenManager, error) {
+	if signing == nil || signing.signKey == nil {
+		return nil, errors.New("signing key is required")
+	}
+
+	if lifetime <= 0 {
+		return nil, errors.New("token lifetime must be positive")
+	}
+
+	m := &TokenManager{
+		lifetime: lifetime,
+		signing:  signing,
+		keys:     make(map[string]*Key),
+	}
+
+	for _, key := range append([]*Key{signing}, verification...) {
+		if existing, ok := m.keys[key.ID]; ok {
+			if existing == key {
+				continue
+			}
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		m.keys[key.ID] = key
+		m.methods = append(m.methods, key.Method.Alg())
+	}
+
+	return m, nil
+}
+
+// Lifetime время жизни токена доступа
+func (m *TokenManager) Lifetime() time.Duration {
+	return m.lifetime
 }
 
-// newJWTString создаёт токен и возвращает его в виде строки.
-func GenerateToken(userID string) (string, error) {
-	// создаём новый токен с алгоритмом подписи HS256 и утверждениями — Claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+// GenerateToken создаёт токен доступа сессии sessionID и возвращает его в виде строки.
+func (m *TokenManager) GenerateToken(userID string, sessionID string) (string, error) {
+	token := jwt.NewWithClaims(m.signing.Method, Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			// Срок окончания времени жизни токена
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TokenLifeTime)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(m.lifetime)),
 		},
-		// собственное утверждение
-		UserID: userID,
+		// собственные утверждения
+		UserID:    userID,
+		SessionID: sessionID,
 	})
+	token.Header["kid"] = m.signing.ID
 
-	// создаём строку токена
-	tokenString, err := token.SignedString([]byte(secretKey))
-	if err != nil {
-		return "", err
-	}
-
-	// возвращаем строку токена
-	return tokenString, nil
+	return token.SignedString(m.signing.signKey)
 }
 
 // ParseToken проверяет токен и возвращает claims
-func ParseToken(tokenString string) (*Claims, error) {
+func (m *TokenManager) ParseToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secretKey), nil
-	})
+		kid, _ := token.Header["kid"].(string)
+		key, ok := m.keys[kid]
+		if !ok {
+			return nil, errUnknownKey
+		}
+		// Алгоритм определяется ключом, а не заголовком токена
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), kid)
+		}
+		return key.verifyKey, nil
+	}, jwt.WithValidMethods(m.methods))
 	if err != nil {
 		return nil, err
 	}
 	if !token.Valid {
 		return nil, jwt.ErrTokenInvalidClaims
 	}
+	// Токены без сессии нельзя отозвать - не принимаем их
+	if claims.SessionID == "" {
+		return nil, errNoSession
+	}
 	return claims, nil
 }
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Минимальная длина секрета HS256
+const minSecretLen = 32
+
+// Key ключ подписи или проверки токенов. У ключей проверки signKey может отсутствовать
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// NewHMACKey ключ HS256. Пустой id - идентификатор вычисляется по секрету
+func NewHMACKey(id string, secret []byte) (*Key, error) {
+	if len(secret) < minSecretLen {
+		return nil, fmt.Errorf("jwt secret must be at least %d bytes long", minSecretLen)
+	}
+	if id == "" {
+		id = deriveKeyID("hs256", secret)
+	}
+	return &Key{ID: id, Method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}, nil
+}
+
+// NewRandomHMACKey случайный ключ HS256. Выданные им токены перестают действовать после перезапуска
+func NewRandomHMACKey() (*Key, error) {
+	secret := make([]byte, minSecretLen)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return NewHMACKey("", secret)
+}
+
+// LoadKeyFile загружает ключ из файла: закрытый ключ RSA (RS256) или Ed25519 (EdDSA) в PEM,
+// открытый ключ в PEM (только проверка) или секрет HS256 в любом другом виде. Пустой id - идентификатор вычисляется по ключу
+func LoadKeyFile(id string, path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return NewHMACKey(id, bytes.TrimSpace(data))
+	}
+
+	key, err := parsePEMKey(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key file %s: %w", path, err)
+	}
+
+	if id == "" {
+		der, err := x509.MarshalPKIXPublicKey(key.verifyKey)
+		if err != nil {
+			return nil, err
+		}
+		id = deriveKeyID(strings.ToLower(key.Method.Alg()), der)
+	}
+	key.ID = id
+
+	return key, nil
+}
+
+func parsePEMKey(block *pem.Block) (*Key, error) {
+	var parsed interface{}
+	var err error
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &Key{Method: jwt.SigningMethodRS256, signKey: k, verifyKey: &k.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &Key{Method: jwt.SigningMethodRS256, verifyKey: k}, nil
+	case ed25519.PrivateKey:
+		return &Key{Method: jwt.SigningMethodEdDSA, signKey: k, verifyKey: k.Public()}, nil
+	case ed25519.PublicKey:
+		return &Key{Method: jwt.SigningMethodEdDSA, verifyKey: k}, nil
+	}
+
+	return nil, errors.New("only RSA and Ed25519 keys are supported")
+}
+
+// deriveKeyID стабильный идентификатор ключа: не раскрывает ключ и не меняется между перезапусками
+func deriveKeyID(prefix string, material []byte) string {
+	sum := sha256.Sum256(material)
+	return prefix + "-" + hex.EncodeToString(sum[:6])
+}
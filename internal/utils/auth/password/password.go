@@ -0,0 +1,141 @@
+// Package password хэширование и проверка паролей пользователей
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Поддерживаемые алгоритмы
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+// Параметры argon2id, кроме количества проходов (его задаёт cost)
+const (
+	argon2Memory  = 64 * 1024 // КиБ
+	argon2Threads = 2
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// MaxLength максимальная длина пароля в байтах: bcrypt учитывает только первые 72 байта и отказывается хэшировать
+// более длинные пароли. Ограничение действует для всех алгоритмов, чтобы смена алгоритма не меняла правила
+const MaxLength = 72
+
+// ErrTooLong - пароль длиннее MaxLength байт
+var ErrTooLong = fmt.Errorf("password must not exceed %d bytes", MaxLength)
+
+var errMalformedHash = errors.New("malformed password hash")
+
+// Hasher хэширует пароли выбранным алгоритмом. Проверяет хэши любого из поддерживаемых алгоритмов,
+// а также пароли, сохранённые до введения хэширования в открытом виде
+type Hasher struct {
+	algorithm string
+	cost      int // bcrypt - cost (4..31), argon2id - количество проходов
+}
+
+// NewHasher создаёт хэшер. cost <= 0 - значение по умолчанию для алгоритма
+func NewHasher(algorithm string, cost int) (*Hasher, error) {
+	switch algorithm {
+	case AlgorithmBcrypt:
+		if cost <= 0 {
+			cost = bcrypt.DefaultCost
+		}
+		if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be from %d to %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case AlgorithmArgon2id:
+		if cost <= 0 {
+			cost = 3
+		}
+	default:
+		return nil, fmt.Errorf("unknown password hashing algorithm %q", algorithm)
+	}
+
+	return &Hasher{algorithm: algorithm, cost: cost}, nil
+}
+
+// Hash возвращает хэш пароля вместе с солью и параметрами алгоритма
+func (h *Hasher) Hash(password string) (string, error) {
+	if len(password) > MaxLength {
+		return "", ErrTooLong
+	}
+
+	if h.algorithm == AlgorithmBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+		return string(hash), err
+	}
+
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, uint32(h.cost), argon2Memory, argon2Threads, argon2KeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, h.cost, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify сравнивает пароль с сохранённым хэшем за время, не зависящее от совпадения.
+// needsRehash - хэш нужно пересчитать текущими настройками (открытый текст, другой алгоритм или cost)
+func (h *Hasher) Verify(stored string, password string) (ok bool, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(stored, "$2a$") || strings.HasPrefix(stored, "$2b$") || strings.HasPrefix(stored, "$2y$"):
+		// Такой пароль не мог быть сохранён - это просто неверный пароль, а не ошибка
+		if len(password) > MaxLength {
+			return false, false, nil
+		}
+		err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, err
+		}
+		cost, err := bcrypt.Cost([]byte(stored))
+		return true, h.algorithm != AlgorithmBcrypt || cost != h.cost, err
+
+	case strings.HasPrefix(stored, "$argon2id$"):
+		var version, memory, iterations int
+		var threads uint8
+		parts := strings.Split(stored, "$")
+		if len(parts) != 6 {
+			return false, false, errMalformedHash
+		}
+		if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+			return false, false, errMalformedHash
+		}
+		if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+			return false, false, errMalformedHash
+		}
+		salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+		if err != nil {
+			return false, false, errMalformedHash
+		}
+		key, err := base64.RawStdEncoding.DecodeString(parts[5])
+		if err != nil {
+			return false, false, errMalformedHash
+		}
+
+		actual := argon2.IDKey([]byte(password), salt, uint32(iterations), uint32(memory), threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(actual, key) != 1 {
+			return false, false, nil
+		}
+		return true, h.algorithm != AlgorithmArgon2id || iterations != h.cost || memory != argon2Memory, nil
+	}
+
+	// Пароль, сохранённый до введения хэширования
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(password)) != 1 {
+		return false, false, nil
+	}
+	return true, true, nil
+}
@@ -0,0 +1,80 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestHashAndVerify(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmBcrypt, AlgorithmArgon2id} {
+		t.Run(algorithm, func(t *testing.T) {
+			hasher, err := NewHasher(algorithm, minCost(algorithm))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			hash, err := hasher.Hash("secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ok, needsRehash, err := hasher.Verify(hash, "secret")
+			if err != nil || !ok || needsRehash {
+				t.Errorf("Verify(correct) = %v, %v, %v", ok, needsRehash, err)
+			}
+
+			ok, _, err = hasher.Verify(hash, "wrong")
+			if err != nil || ok {
+				t.Errorf("Verify(wrong) = %v, %v", ok, err)
+			}
+		})
+	}
+}
+
+func TestVerifyRequestsRehash(t *testing.T) {
+	bcryptHasher, _ := NewHasher(AlgorithmBcrypt, 4)
+	argonHasher, _ := NewHasher(AlgorithmArgon2id, 1)
+
+	bcryptHash, err := bcryptHasher.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Хэш другого алгоритма и пароль в открытом виде принимаются, но требуют пересчёта
+	for name, stored := range map[string]string{"other algorithm": bcryptHash, "plaintext": "secret"} {
+		ok, needsRehash, err := argonHasher.Verify(stored, "secret")
+		if err != nil || !ok || !needsRehash {
+			t.Errorf("%s: Verify = %v, %v, %v; want ok and rehash", name, ok, needsRehash, err)
+		}
+	}
+}
+
+func TestTooLongPassword(t *testing.T) {
+	long := strings.Repeat("a", MaxLength+1)
+
+	for _, algorithm := range []string{AlgorithmBcrypt, AlgorithmArgon2id} {
+		hasher, _ := NewHasher(algorithm, minCost(algorithm))
+		if _, err := hasher.Hash(long); !errors.Is(err, ErrTooLong) {
+			t.Errorf("%s: Hash(long) = %v, want ErrTooLong", algorithm, err)
+		}
+		if _, err := hasher.Hash(long[:MaxLength]); err != nil {
+			t.Errorf("%s: Hash(%d bytes) = %v", algorithm, MaxLength, err)
+		}
+	}
+
+	// При входе слишком длинный пароль - просто неверный пароль
+	hasher, _ := NewHasher(AlgorithmBcrypt, 4)
+	hash, _ := hasher.Hash(long[:MaxLength])
+	ok, _, err := hasher.Verify(hash, long)
+	if ok || err != nil {
+		t.Errorf("Verify(long) = %v, %v; want false, nil", ok, err)
+	}
+}
+
+func minCost(algorithm string) int {
+	if algorithm == AlgorithmBcrypt {
+		return 4
+	}
+	return 1
+}